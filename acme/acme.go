@@ -0,0 +1,862 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package acme implements the server side of ACME (RFC 8555), the protocol
+// spoken by certbot, Go's autocert, and similar tools for obtaining
+// certificates. The actual issuance, and any challenge types beyond http-01,
+// are supplied by the caller, so this package knows nothing of Tao.
+//
+// Only account registration and the order flow are implemented. The directory
+// does not offer revokeCert, so certificates are revoked using whatever
+// interface the caller provides, nor keyChange, so an account key can't be
+// rolled over. A client with a new key simply registers a new account.
+package acme
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Note: All state is kept in memory, so accounts, orders, and certificate URLs
+// are forgotten when the server restarts. ACME clients simply register again.
+// Orders, along with their authorizations and certificate URLs, are forgotten
+// once they expire, accounts are forgotten once they go unused for a while, and
+// nonces are only good for a limited time.
+
+// Object status values.
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusDeactivated = "deactivated"
+	StatusExpired     = "expired"
+)
+
+// Problem is an RFC 7807 problem document, as used for ACME errors.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func problem(status int, kind, format string, args ...interface{}) *Problem {
+	return &Problem{
+		Type:   "urn:ietf:params:acme:error:" + kind,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func (p *Problem) Error() string {
+	return p.Type + ": " + p.Detail
+}
+
+// Identifier names something for which a certificate is requested, i.e. a dns
+// name or ip address.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+// Account is an ACME account, which is just a registered public key.
+type Account struct {
+	ID         string           `json:"-"`
+	Key        crypto.PublicKey `json:"-"`
+	Thumbprint string           `json:"-"`
+	Status     string           `json:"status"`
+	Contact    []string         `json:"contact,omitempty"`
+	Orders     string           `json:"orders"`
+
+	orders []*Order
+	used   time.Time
+}
+
+// Order is a request for a certificate covering some set of identifiers.
+type Order struct {
+	ID             string           `json:"-"`
+	Account        *Account         `json:"-"`
+	Authorizations []*Authorization `json:"-"`
+	Status         string           `json:"status"`
+	Expires        time.Time        `json:"expires"`
+	Identifiers    []Identifier     `json:"identifiers"`
+	AuthzURLs      []string         `json:"authorizations"`
+	Finalize       string           `json:"finalize"`
+	Certificate    string           `json:"certificate,omitempty"`
+	Error          *Problem         `json:"error,omitempty"`
+
+	chain []byte
+}
+
+// Authorization is the proof that an account controls an identifier.
+type Authorization struct {
+	ID         string       `json:"-"`
+	Order      *Order       `json:"-"`
+	Status     string       `json:"status"`
+	Expires    time.Time    `json:"expires"`
+	Identifier Identifier   `json:"identifier"`
+	Challenges []*Challenge `json:"challenges"`
+
+	// Evidence is whatever was returned by the validator for the challenge
+	// that satisfied this authorization.
+	Evidence interface{} `json:"-"`
+}
+
+// Challenge is one way of satisfying an authorization.
+type Challenge struct {
+	ID        string     `json:"-"`
+	Type      string     `json:"type"`
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	Token     string     `json:"token"`
+	Validated *time.Time `json:"validated,omitempty"`
+	Error     *Problem   `json:"error,omitempty"`
+
+	authz *Authorization
+}
+
+// Validator checks a response to a challenge. The keyAuth is the expected key
+// authorization, i.e. the token and account key thumbprint, and payload is the
+// body of the client's response to the challenge. Any evidence returned is
+// attached to the authorization.
+type Validator func(authz *Authorization, keyAuth string, payload []byte) (evidence interface{}, err error)
+
+// Issuer signs a certificate for a finalized order, returning the DER-encoded
+// certificate chain, leaf first. The CSR signature and names have already been
+// checked against the order.
+type Issuer func(order *Order, csr *x509.CertificateRequest) ([][]byte, error)
+
+// RateLimitError can be returned by an Issuer to refuse an order for now. The
+// order remains ready, so the client can finalize it again later.
+type RateLimitError struct {
+	Detail     string
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("%s; retry after %v", e.Detail, e.RetryAfter)
+}
+
+// Server is an ACME server. It implements http.Handler.
+type Server struct {
+	// BaseURL is the externally visible url of the server, without a trailing
+	// slash. The directory is at BaseURL + "/directory".
+	BaseURL string
+
+	// Issue signs certificates.
+	Issue Issuer
+
+	// Validators holds the supported challenge types.
+	Validators map[string]Validator
+
+	lock       sync.Mutex
+	nonces     map[string]time.Time
+	swept      time.Time
+	accounts   map[string]*Account
+	byKey      map[string]*Account
+	orders     map[string]*Order
+	authzs     map[string]*Authorization
+	challenges map[string]*Challenge
+}
+
+// NewServer creates an ACME server.
+func NewServer(baseURL string, issue Issuer, validators map[string]Validator) *Server {
+	return &Server{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Issue:      issue,
+		Validators: validators,
+		nonces:     make(map[string]time.Time),
+		accounts:   make(map[string]*Account),
+		byKey:      make(map[string]*Account),
+		orders:     make(map[string]*Order),
+		authzs:     make(map[string]*Authorization),
+		challenges: make(map[string]*Challenge),
+	}
+}
+
+const (
+	// maxNonces bounds the number of outstanding nonces, and nonceLifetime
+	// bounds how long each is good for.
+	maxNonces     = 10000
+	nonceLifetime = time.Hour
+
+	// maxOrders bounds the number of orders that have not yet expired, and
+	// orderLifetime is how long each order and its authorizations last.
+	maxOrders     = 10000
+	orderLifetime = 7 * 24 * time.Hour
+
+	// maxAccounts bounds the number of registered accounts, and accountIdle is
+	// how long an account lasts without being used.
+	maxAccounts = 10000
+	accountIdle = 90 * 24 * time.Hour
+
+	// sweepPeriod is how often expired orders, idle accounts, and nonces are
+	// cleaned up.
+	sweepPeriod = time.Minute
+)
+
+func newID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b64.EncodeToString(b)
+}
+
+func (s *Server) newNonce() string {
+	n := newID()
+	now := time.Now()
+	s.lock.Lock()
+	if len(s.nonces) >= maxNonces {
+		s.expireNonces(now)
+		if len(s.nonces) >= maxNonces {
+			s.nonces = make(map[string]time.Time)
+		}
+	}
+	s.nonces[n] = now
+	s.lock.Unlock()
+	return n
+}
+
+// expireNonces discards nonces that are too old to be used. Callers must hold
+// s.lock.
+func (s *Server) expireNonces(now time.Time) {
+	for n, t := range s.nonces {
+		if now.Sub(t) >= nonceLifetime {
+			delete(s.nonces, n)
+		}
+	}
+}
+
+// sweep periodically discards expired orders, along with their authorizations
+// and challenges, idle accounts, and expired nonces.
+func (s *Server) sweep(now time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if now.Sub(s.swept) < sweepPeriod {
+		return
+	}
+	s.swept = now
+	s.expireNonces(now)
+	for id, o := range s.orders {
+		if now.Before(o.Expires) {
+			continue
+		}
+		delete(s.orders, id)
+		for _, a := range o.Authorizations {
+			delete(s.authzs, a.ID)
+			for _, c := range a.Challenges {
+				delete(s.challenges, c.ID)
+			}
+		}
+	}
+	for id, acct := range s.accounts {
+		var live []*Order
+		for _, o := range acct.orders {
+			if s.orders[o.ID] == o {
+				live = append(live, o)
+			}
+		}
+		acct.orders = live
+		if len(live) == 0 && now.Sub(acct.used) >= accountIdle {
+			delete(s.accounts, id)
+			delete(s.byKey, acct.Thumbprint)
+		}
+	}
+}
+
+// checkExpiry marks an order, and its authorizations, as expired once past
+// their expiry time. An order already being processed, or already valid, is
+// left as it is. Callers must hold s.lock.
+func checkExpiry(o *Order, now time.Time) {
+	if now.Before(o.Expires) {
+		return
+	}
+	for _, a := range o.Authorizations {
+		if a.Status == StatusPending || a.Status == StatusValid {
+			a.Status = StatusExpired
+		}
+	}
+	if o.Status == StatusPending || o.Status == StatusReady {
+		o.Status = StatusInvalid
+		o.Error = problem(403, "unauthorized", "order has expired")
+	}
+}
+
+func (s *Server) url(kind, id string) string {
+	if id == "" {
+		return s.BaseURL + "/" + kind
+	}
+	return s.BaseURL + "/" + kind + "/" + id
+}
+
+// ServeHTTP handles an ACME request.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.sweep(time.Now())
+	w.Header().Set("Replay-Nonce", s.newNonce())
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"index\"", s.url("directory", "")))
+
+	p := strings.TrimPrefix(r.URL.Path, s.basePath())
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	kind, id := parts[0], ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+
+	switch kind {
+	case "directory":
+		writeJSON(w, 200, map[string]interface{}{
+			"newNonce":   s.url("new-nonce", ""),
+			"newAccount": s.url("new-account", ""),
+			"newOrder":   s.url("new-order", ""),
+		})
+		return
+	case "new-nonce":
+		if r.Method == "HEAD" {
+			w.WriteHeader(200)
+		} else {
+			w.WriteHeader(204)
+		}
+		return
+	}
+
+	if r.Method != "POST" {
+		writeProblem(w, problem(405, "malformed", "use POST"))
+		return
+	}
+	acct, key, payload, prob := s.authenticate(r, kind == "new-account")
+	if prob != nil {
+		writeProblem(w, prob)
+		return
+	}
+
+	switch kind {
+	case "new-account":
+		s.newAccount(w, key, payload)
+	case "account":
+		s.updateAccount(w, acct, id, payload)
+	case "orders":
+		s.listOrders(w, acct, id)
+	case "new-order":
+		s.newOrder(w, acct, payload)
+	case "order":
+		s.getOrder(w, acct, id)
+	case "finalize":
+		s.finalize(w, acct, id, payload)
+	case "authz":
+		s.getAuthz(w, acct, id)
+	case "chall":
+		s.respond(w, acct, id, payload)
+	case "cert":
+		s.getCert(w, acct, id)
+	default:
+		writeProblem(w, problem(404, "malformed", "no such resource"))
+	}
+}
+
+// authenticate checks the JWS signature and nonce for a POST request. For new
+// accounts, the key is taken from the request, otherwise the key is that of
+// the account named by the request.
+func (s *Server) authenticate(r *http.Request, newAccount bool) (*Account, crypto.PublicKey, []byte, *Problem) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1024*1024))
+	if err != nil {
+		return nil, nil, nil, problem(400, "malformed", "can't read request")
+	}
+	var j jws
+	if err := json.Unmarshal(body, &j); err != nil {
+		return nil, nil, nil, problem(400, "malformed", "bad JWS: %s", err)
+	}
+	hdata, err := b64.DecodeString(j.Protected)
+	if err != nil {
+		return nil, nil, nil, problem(400, "malformed", "bad JWS header")
+	}
+	var h header
+	if err := json.Unmarshal(hdata, &h); err != nil {
+		return nil, nil, nil, problem(400, "malformed", "bad JWS header: %s", err)
+	}
+	payload, err := b64.DecodeString(j.Payload)
+	if err != nil {
+		return nil, nil, nil, problem(400, "malformed", "bad JWS payload")
+	}
+
+	s.lock.Lock()
+	issued, ok := s.nonces[h.Nonce]
+	delete(s.nonces, h.Nonce)
+	s.lock.Unlock()
+	if !ok || time.Since(issued) >= nonceLifetime {
+		return nil, nil, nil, problem(400, "badNonce", "unknown or reused nonce")
+	}
+	if h.URL != s.BaseURL+strings.TrimPrefix(r.URL.Path, s.basePath()) {
+		return nil, nil, nil, problem(401, "unauthorized", "JWS url does not match request")
+	}
+
+	var acct *Account
+	var key crypto.PublicKey
+	if newAccount {
+		if h.JWK == nil || h.KID != "" {
+			return nil, nil, nil, problem(400, "malformed", "new account request needs jwk")
+		}
+		key, err = parseJWK(h.JWK)
+		if err != nil {
+			return nil, nil, nil, problem(400, "badPublicKey", "%s", err)
+		}
+	} else {
+		prefix := s.url("account", "") + "/"
+		if h.JWK != nil || !strings.HasPrefix(h.KID, prefix) {
+			return nil, nil, nil, problem(400, "malformed", "request needs account kid")
+		}
+		s.lock.Lock()
+		acct = s.accounts[strings.TrimPrefix(h.KID, prefix)]
+		s.lock.Unlock()
+		if acct == nil {
+			return nil, nil, nil, problem(400, "accountDoesNotExist", "no such account")
+		}
+		if acct.Status != StatusValid {
+			return nil, nil, nil, problem(401, "unauthorized", "account is %s", acct.Status)
+		}
+		key = acct.Key
+	}
+	if err := j.verify(h.Alg, key); err != nil {
+		return nil, nil, nil, problem(400, "malformed", "JWS verification failed: %s", err)
+	}
+	if acct != nil {
+		s.lock.Lock()
+		acct.used = time.Now()
+		s.lock.Unlock()
+	}
+	return acct, key, payload, nil
+}
+
+func (s *Server) basePath() string {
+	base, err := url.Parse(s.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimRight(base.Path, "/")
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, key crypto.PublicKey, payload []byte) {
+	var req struct {
+		Contact            []string `json:"contact"`
+		OnlyReturnExisting bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, problem(400, "malformed", "bad account request: %s", err))
+		return
+	}
+	t, err := thumbprint(key)
+	if err != nil {
+		writeProblem(w, problem(400, "badPublicKey", "%s", err))
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if acct := s.byKey[t]; acct != nil {
+		acct.used = time.Now()
+		w.Header().Set("Location", s.url("account", acct.ID))
+		writeJSON(w, 200, acct)
+		return
+	}
+	if req.OnlyReturnExisting {
+		writeProblem(w, problem(400, "accountDoesNotExist", "no account for this key"))
+		return
+	}
+	if len(s.accounts) >= maxAccounts {
+		w.Header().Set("Retry-After", strconv.Itoa(int(sweepPeriod/time.Second)))
+		writeProblem(w, problem(429, "rateLimited", "too many accounts"))
+		return
+	}
+	acct := &Account{
+		ID:         newID(),
+		Key:        key,
+		Thumbprint: t,
+		Status:     StatusValid,
+		Contact:    req.Contact,
+		used:       time.Now(),
+	}
+	acct.Orders = s.url("orders", acct.ID)
+	s.accounts[acct.ID] = acct
+	s.byKey[t] = acct
+	w.Header().Set("Location", s.url("account", acct.ID))
+	writeJSON(w, 201, acct)
+}
+
+func (s *Server) updateAccount(w http.ResponseWriter, acct *Account, id string, payload []byte) {
+	if id != acct.ID {
+		writeProblem(w, problem(403, "unauthorized", "not your account"))
+		return
+	}
+	var req struct {
+		Contact []string `json:"contact"`
+		Status  string   `json:"status"`
+	}
+	if len(payload) > 0 {
+		if err := json.Unmarshal(payload, &req); err != nil {
+			writeProblem(w, problem(400, "malformed", "bad account request: %s", err))
+			return
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if req.Contact != nil {
+		acct.Contact = req.Contact
+	}
+	if req.Status == StatusDeactivated {
+		acct.Status = StatusDeactivated
+	}
+	writeJSON(w, 200, acct)
+}
+
+func (s *Server) listOrders(w http.ResponseWriter, acct *Account, id string) {
+	if id != acct.ID {
+		writeProblem(w, problem(403, "unauthorized", "not your account"))
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	urls := []string{}
+	for _, o := range acct.orders {
+		urls = append(urls, s.url("order", o.ID))
+	}
+	writeJSON(w, 200, map[string]interface{}{"orders": urls})
+}
+
+// checkIdentifier normalizes an identifier, or rejects it.
+func checkIdentifier(id Identifier) (Identifier, error) {
+	switch id.Type {
+	case "dns":
+		v := strings.ToLower(id.Value)
+		if v == "" || strings.HasPrefix(v, "*") || net.ParseIP(v) != nil {
+			return id, fmt.Errorf("unsupported dns identifier %q", id.Value)
+		}
+		return Identifier{Type: "dns", Value: v}, nil
+	case "ip":
+		ip := net.ParseIP(id.Value)
+		if ip == nil {
+			return id, fmt.Errorf("bad ip identifier %q", id.Value)
+		}
+		return Identifier{Type: "ip", Value: ip.String()}, nil
+	default:
+		return id, fmt.Errorf("unsupported identifier type %q", id.Type)
+	}
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, acct *Account, payload []byte) {
+	var req struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, problem(400, "malformed", "bad order request: %s", err))
+		return
+	}
+	if len(req.Identifiers) == 0 {
+		writeProblem(w, problem(400, "malformed", "no identifiers"))
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.orders) >= maxOrders {
+		w.Header().Set("Retry-After", strconv.Itoa(int(sweepPeriod/time.Second)))
+		writeProblem(w, problem(429, "rateLimited", "too many outstanding orders"))
+		return
+	}
+	expires := time.Now().Add(orderLifetime).UTC().Truncate(time.Second)
+	o := &Order{
+		ID:      newID(),
+		Account: acct,
+		Status:  StatusPending,
+		Expires: expires,
+	}
+	o.Finalize = s.url("finalize", o.ID)
+	seen := make(map[Identifier]bool)
+	for _, id := range req.Identifiers {
+		id, err := checkIdentifier(id)
+		if err != nil {
+			writeProblem(w, problem(400, "rejectedIdentifier", "%s", err))
+			return
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		a := &Authorization{
+			ID:         newID(),
+			Order:      o,
+			Status:     StatusPending,
+			Expires:    expires,
+			Identifier: id,
+		}
+		var types []string
+		for t := range s.Validators {
+			types = append(types, t)
+		}
+		sort.Strings(types)
+		for _, t := range types {
+			c := &Challenge{ID: newID(), authz: a, Type: t, Status: StatusPending, Token: newID()}
+			c.URL = s.url("chall", c.ID)
+			a.Challenges = append(a.Challenges, c)
+		}
+		o.Identifiers = append(o.Identifiers, id)
+		o.Authorizations = append(o.Authorizations, a)
+		o.AuthzURLs = append(o.AuthzURLs, s.url("authz", a.ID))
+	}
+	s.orders[o.ID] = o
+	for _, a := range o.Authorizations {
+		s.authzs[a.ID] = a
+		for _, c := range a.Challenges {
+			s.challenges[c.ID] = c
+		}
+	}
+	acct.orders = append(acct.orders, o)
+	w.Header().Set("Location", s.url("order", o.ID))
+	writeJSON(w, 201, o)
+}
+
+func (s *Server) getOrder(w http.ResponseWriter, acct *Account, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o := s.orders[id]
+	if o == nil || o.Account != acct {
+		writeProblem(w, problem(404, "malformed", "no such order"))
+		return
+	}
+	checkExpiry(o, time.Now())
+	writeJSON(w, 200, o)
+}
+
+func (s *Server) getAuthz(w http.ResponseWriter, acct *Account, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := s.authzs[id]
+	if a == nil || a.Order.Account != acct {
+		writeProblem(w, problem(404, "malformed", "no such authorization"))
+		return
+	}
+	checkExpiry(a.Order, time.Now())
+	writeJSON(w, 200, a)
+}
+
+// respond starts validation of a challenge.
+func (s *Server) respond(w http.ResponseWriter, acct *Account, id string, payload []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := s.challenges[id]
+	if c == nil || c.authz.Order.Account != acct {
+		writeProblem(w, problem(404, "malformed", "no such challenge"))
+		return
+	}
+	checkExpiry(c.authz.Order, time.Now())
+	if len(payload) == 0 || c.Status != StatusPending || c.authz.Status != StatusPending {
+		// POST-as-GET, or a repeated response.
+		writeJSON(w, 200, c)
+		return
+	}
+	c.Status = StatusProcessing
+	keyAuth := c.Token + "." + acct.Thumbprint
+	go s.validate(c, s.Validators[c.Type], keyAuth, payload)
+	w.Header().Add("Link", fmt.Sprintf("<%s>;rel=\"up\"", s.url("authz", c.authz.ID)))
+	writeJSON(w, 200, c)
+}
+
+func (s *Server) validate(c *Challenge, v Validator, keyAuth string, payload []byte) {
+	evidence, err := v(c.authz, keyAuth, payload)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	a := c.authz
+	o := a.Order
+	checkExpiry(o, time.Now())
+	if err == nil && a.Status != StatusPending && a.Status != StatusValid {
+		err = fmt.Errorf("authorization is %s", a.Status)
+	}
+	if err != nil {
+		c.Status = StatusInvalid
+		c.Error = problem(403, "unauthorized", "%s", err)
+		a.Status = StatusInvalid
+		o.Status = StatusInvalid
+		o.Error = c.Error
+		return
+	}
+	now := time.Now().UTC()
+	c.Status = StatusValid
+	c.Validated = &now
+	a.Status = StatusValid
+	a.Evidence = evidence
+	if o.Status != StatusPending {
+		return
+	}
+	for _, a := range o.Authorizations {
+		if a.Status != StatusValid {
+			return
+		}
+	}
+	o.Status = StatusReady
+}
+
+// csrNames collects the identifiers named in a CSR.
+func csrNames(csr *x509.CertificateRequest) map[Identifier]bool {
+	names := make(map[Identifier]bool)
+	for _, n := range csr.DNSNames {
+		names[Identifier{Type: "dns", Value: strings.ToLower(n)}] = true
+	}
+	for _, ip := range csr.IPAddresses {
+		names[Identifier{Type: "ip", Value: ip.String()}] = true
+	}
+	return names
+}
+
+func (s *Server) finalize(w http.ResponseWriter, acct *Account, id string, payload []byte) {
+	var req struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(payload, &req); err != nil {
+		writeProblem(w, problem(400, "malformed", "bad finalize request: %s", err))
+		return
+	}
+	der, err := b64.DecodeString(req.CSR)
+	if err != nil {
+		writeProblem(w, problem(400, "badCSR", "bad CSR encoding"))
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		writeProblem(w, problem(400, "badCSR", "%s", err))
+		return
+	}
+
+	s.lock.Lock()
+	o := s.orders[id]
+	if o == nil || o.Account != acct {
+		s.lock.Unlock()
+		writeProblem(w, problem(404, "malformed", "no such order"))
+		return
+	}
+	checkExpiry(o, time.Now())
+	if o.Status != StatusReady {
+		s.lock.Unlock()
+		writeProblem(w, problem(403, "orderNotReady", "order is %s", o.Status))
+		return
+	}
+	names := csrNames(csr)
+	ok := len(names) == len(o.Identifiers)
+	cnOK := csr.Subject.CommonName == ""
+	for _, id := range o.Identifiers {
+		ok = ok && names[id]
+		cnOK = cnOK || strings.ToLower(csr.Subject.CommonName) == id.Value
+	}
+	if !ok || !cnOK {
+		s.lock.Unlock()
+		writeProblem(w, problem(400, "badCSR", "CSR names do not match order"))
+		return
+	}
+	o.Status = StatusProcessing
+	s.lock.Unlock()
+
+	chain, err := s.Issue(o, csr)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if rl, ok := err.(*RateLimitError); ok {
+		o.Status = StatusReady
+		w.Header().Set("Retry-After", strconv.Itoa(int(rl.RetryAfter/time.Second)+1))
+		writeProblem(w, problem(429, "rateLimited", "%s", rl.Detail))
+		return
+	}
+	if err != nil {
+		o.Status = StatusInvalid
+		o.Error = problem(403, "unauthorized", "%s", err)
+		writeProblem(w, o.Error)
+		return
+	}
+	for _, c := range chain {
+		o.chain = append(o.chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c})...)
+	}
+	o.Status = StatusValid
+	o.Certificate = s.url("cert", o.ID)
+	w.Header().Set("Location", s.url("order", o.ID))
+	writeJSON(w, 200, o)
+}
+
+func (s *Server) getCert(w http.ResponseWriter, acct *Account, id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	o := s.orders[id]
+	if o == nil || o.Account != acct || o.chain == nil {
+		writeProblem(w, problem(404, "malformed", "no such certificate"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.Write(o.chain)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeProblem(w http.ResponseWriter, p *Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// HTTP01 validates the standard http-01 challenge, by fetching the key
+// authorization from the identifier's http server. It is meant for testing, as
+// it proves only control over whatever answers on port 80.
+func HTTP01(authz *Authorization, keyAuth string, payload []byte) (interface{}, error) {
+	host := authz.Identifier.Value
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	token := strings.SplitN(keyAuth, ".", 2)[0]
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + host + "/.well-known/acme-challenge/" + token)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("challenge fetch returned %s", resp.Status)
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return nil, fmt.Errorf("wrong key authorization")
+	}
+	return nil, nil
+}
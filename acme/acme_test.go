@@ -0,0 +1,298 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+	"time"
+)
+
+// client is a bare-bones ACME client, just enough to exercise the server.
+type client struct {
+	t     *testing.T
+	key   *ecdsa.PrivateKey
+	kid   string
+	nonce string
+}
+
+func (c *client) post(url string, payload interface{}) (*http.Response, []byte) {
+	resp, data := c.try(url, payload)
+	if resp.StatusCode >= 300 {
+		c.t.Fatalf("POST %s: %s: %s", url, resp.Status, data)
+	}
+	return resp, data
+}
+
+// try is like post, but returns error responses rather than failing.
+func (c *client) try(url string, payload interface{}) (*http.Response, []byte) {
+	var p []byte
+	if payload != nil {
+		var err error
+		if p, err = json.Marshal(payload); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	h := map[string]interface{}{"alg": "ES256", "nonce": c.nonce, "url": url}
+	if c.kid != "" {
+		h["kid"] = c.kid
+	} else {
+		h["jwk"] = map[string]string{
+			"kty": "EC",
+			"crv": "P-256",
+			"x":   b64.EncodeToString(pad(c.key.X.Bytes(), 32)),
+			"y":   b64.EncodeToString(pad(c.key.Y.Bytes(), 32)),
+		}
+	}
+	hdata, _ := json.Marshal(h)
+	j := jws{Protected: b64.EncodeToString(hdata), Payload: b64.EncodeToString(p)}
+	d := sha256.Sum256([]byte(j.Protected + "." + j.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, d[:])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	j.Signature = b64.EncodeToString(append(pad(r.Bytes(), 32), pad(s.Bytes(), 32)...))
+	body, _ := json.Marshal(j)
+	resp, err := http.Post(url, "application/jose+json", bytes.NewReader(body))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp, data
+}
+
+func TestOrderFlow(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issue := func(o *Order, csr *x509.CertificateRequest) ([][]byte, error) {
+		if o.Authorizations[0].Evidence != "ok" {
+			return nil, fmt.Errorf("missing evidence")
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, csr.PublicKey, caKey)
+		return [][]byte{der}, err
+	}
+	validators := map[string]Validator{
+		"test-01": func(a *Authorization, keyAuth string, payload []byte) (interface{}, error) {
+			var p struct{ KeyAuthorization string }
+			json.Unmarshal(payload, &p)
+			if p.KeyAuthorization != keyAuth {
+				return nil, fmt.Errorf("wrong key authorization")
+			}
+			return "ok", nil
+		},
+	}
+	var s *Server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	s = NewServer(ts.URL+"/acme", issue, validators)
+
+	resp, err := http.Get(ts.URL + "/acme/directory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dir map[string]string
+	json.NewDecoder(resp.Body).Decode(&dir)
+	resp.Body.Close()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := &client{t: t, key: key}
+	resp, err = http.Head(dir["newNonce"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.nonce = resp.Header.Get("Replay-Nonce")
+
+	resp, _ = c.post(dir["newAccount"], map[string]interface{}{"termsOfServiceAgreed": true})
+	c.kid = resp.Header.Get("Location")
+
+	var order Order
+	_, data := c.post(dir["newOrder"], map[string]interface{}{
+		"identifiers": []Identifier{{Type: "dns", Value: "example.com"}},
+	})
+	json.Unmarshal(data, &order)
+
+	var authz Authorization
+	_, data = c.post(order.AuthzURLs[0], nil)
+	json.Unmarshal(data, &authz)
+	thumb, _ := thumbprint(&key.PublicKey)
+	chal := authz.Challenges[0]
+	c.post(chal.URL, map[string]string{"KeyAuthorization": chal.Token + "." + thumb})
+
+	for i := 0; authz.Status != StatusValid; i++ {
+		if i > 100 {
+			t.Fatalf("authorization is %s", authz.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, data = c.post(order.AuthzURLs[0], nil)
+		json.Unmarshal(data, &authz)
+	}
+
+	csrKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "example.com"},
+		DNSNames: []string{"example.com"},
+	}, csrKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data = c.post(order.Finalize, map[string]string{"csr": b64.EncodeToString(csr)})
+	json.Unmarshal(data, &order)
+	if order.Status != StatusValid || order.Certificate == "" {
+		t.Fatalf("order is %s", order.Status)
+	}
+	_, data = c.post(order.Certificate, nil)
+	if !bytes.HasPrefix(data, []byte("-----BEGIN CERTIFICATE-----")) {
+		t.Fatalf("bad certificate chain: %s", data)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	issue := func(o *Order, csr *x509.CertificateRequest) ([][]byte, error) {
+		return nil, fmt.Errorf("not reached")
+	}
+	var s *Server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	s = NewServer(ts.URL+"/acme", issue, nil)
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := &client{t: t, key: key}
+	resp, err := http.Head(s.url("new-nonce", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	resp, _ = c.post(s.url("new-account", ""), map[string]interface{}{"termsOfServiceAgreed": true})
+	c.kid = resp.Header.Get("Location")
+
+	var order Order
+	resp, data := c.post(s.url("new-order", ""), map[string]interface{}{
+		"identifiers": []Identifier{{Type: "dns", Value: "example.com"}},
+	})
+	json.Unmarshal(data, &order)
+	orderURL := resp.Header.Get("Location")
+
+	// An expired order can no longer be used.
+	s.lock.Lock()
+	for _, o := range s.orders {
+		o.Expires = time.Now().Add(-time.Second)
+	}
+	s.lock.Unlock()
+	_, data = c.post(orderURL, nil)
+	json.Unmarshal(data, &order)
+	if order.Status != StatusInvalid {
+		t.Fatalf("expired order is %s", order.Status)
+	}
+	var authz Authorization
+	_, data = c.post(order.AuthzURLs[0], nil)
+	json.Unmarshal(data, &authz)
+	if authz.Status != StatusExpired {
+		t.Fatalf("expired authorization is %s", authz.Status)
+	}
+
+	// Expired orders are eventually forgotten.
+	s.sweep(time.Now().Add(sweepPeriod))
+	if resp, _ := c.try(orderURL, nil); resp.StatusCode != 404 {
+		t.Fatalf("expected expired order to be gone, got %s", resp.Status)
+	}
+	s.lock.Lock()
+	n := len(s.orders) + len(s.authzs) + len(s.challenges) + len(s.accounts[path.Base(c.kid)].orders)
+	s.lock.Unlock()
+	if n != 0 {
+		t.Fatalf("expected no remaining order state, found %d objects", n)
+	}
+
+	// Stale nonces are rejected.
+	s.lock.Lock()
+	s.nonces[c.nonce] = time.Now().Add(-nonceLifetime)
+	s.lock.Unlock()
+	if resp, data := c.try(orderURL, nil); resp.StatusCode != 400 || !bytes.Contains(data, []byte("badNonce")) {
+		t.Fatalf("expected stale nonce to be rejected, got %s: %s", resp.Status, data)
+	}
+
+	// Idle accounts are eventually forgotten.
+	s.sweep(time.Now().Add(accountIdle))
+	s.lock.Lock()
+	n = len(s.accounts) + len(s.byKey)
+	s.lock.Unlock()
+	if n != 0 {
+		t.Fatalf("expected idle account to be gone, found %d objects", n)
+	}
+}
+
+func TestAccountLimit(t *testing.T) {
+	issue := func(o *Order, csr *x509.CertificateRequest) ([][]byte, error) {
+		return nil, fmt.Errorf("not reached")
+	}
+	var s *Server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+	s = NewServer(ts.URL+"/acme", issue, nil)
+
+	s.lock.Lock()
+	for i := 0; i < maxAccounts; i++ {
+		id := fmt.Sprintf("%d", i)
+		s.accounts[id] = &Account{ID: id, Thumbprint: id, Status: StatusValid, used: time.Now()}
+		s.byKey[id] = s.accounts[id]
+	}
+	s.lock.Unlock()
+
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c := &client{t: t, key: key}
+	resp, err := http.Head(s.url("new-nonce", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	resp, data := c.try(s.url("new-account", ""), map[string]interface{}{"termsOfServiceAgreed": true})
+	if resp.StatusCode != 429 || !bytes.Contains(data, []byte("rateLimited")) {
+		t.Fatalf("expected too many accounts to be refused, got %s: %s", resp.Status, data)
+	}
+
+	// Once idle accounts are forgotten, registration succeeds again.
+	s.lock.Lock()
+	for _, acct := range s.accounts {
+		acct.used = time.Now().Add(-accountIdle)
+	}
+	s.lock.Unlock()
+	s.sweep(time.Now().Add(sweepPeriod))
+	c.post(s.url("new-account", ""), map[string]interface{}{"termsOfServiceAgreed": true})
+}
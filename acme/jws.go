@@ -0,0 +1,166 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+// This file handles the small subset of JOSE (RFC 7515, 7517, 7638) that ACME
+// requires: flattened JWS objects signed with ES256 or RS256, and JWK public
+// keys with their thumbprints.
+
+var b64 = base64.RawURLEncoding
+
+// jws is a flattened JWS object, as sent in every ACME POST request.
+type jws struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// header is the protected header of a JWS object.
+type header struct {
+	Alg   string          `json:"alg"`
+	Nonce string          `json:"nonce"`
+	URL   string          `json:"url"`
+	JWK   json.RawMessage `json:"jwk,omitempty"`
+	KID   string          `json:"kid,omitempty"`
+}
+
+// jwk is a JSON web key, holding an EC or RSA public key.
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// parseJWK decodes a JSON web key.
+func parseJWK(data []byte) (crypto.PublicKey, error) {
+	var k jwk
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, err
+	}
+	switch k.Kty {
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := b64.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := b64.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, fmt.Errorf("invalid EC point")
+		}
+		return pub, nil
+	case "RSA":
+		n, err := b64.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := b64.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RSA exponent")
+		}
+		pub := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key too small")
+		}
+		return pub, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// thumbprint computes the RFC 7638 thumbprint of a public key.
+func thumbprint(key crypto.PublicKey) (string, error) {
+	var s string
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		s = fmt.Sprintf(`{"crv":"P-256","kty":"EC","x":"%s","y":"%s"}`,
+			b64.EncodeToString(pad(key.X.Bytes(), 32)),
+			b64.EncodeToString(pad(key.Y.Bytes(), 32)))
+	case *rsa.PublicKey:
+		s = fmt.Sprintf(`{"e":"%s","kty":"RSA","n":"%s"}`,
+			b64.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			b64.EncodeToString(key.N.Bytes()))
+	default:
+		return "", fmt.Errorf("unsupported key type")
+	}
+	h := sha256.Sum256([]byte(s))
+	return b64.EncodeToString(h[:]), nil
+}
+
+func pad(b []byte, n int) []byte {
+	if len(b) >= n {
+		return b
+	}
+	return append(make([]byte, n-len(b)), b...)
+}
+
+// verify checks the JWS signature using the given key.
+func (j *jws) verify(alg string, key crypto.PublicKey) error {
+	sig, err := b64.DecodeString(j.Signature)
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(j.Protected + "." + j.Payload))
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		if alg != "ES256" || len(sig) != 64 {
+			return fmt.Errorf("bad signature algorithm")
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		if !ecdsa.Verify(key, h[:], r, s) {
+			return fmt.Errorf("bad signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return fmt.Errorf("bad signature algorithm")
+		}
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig)
+	default:
+		return fmt.Errorf("unsupported key type")
+	}
+}
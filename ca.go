@@ -15,18 +15,19 @@
 package taoca
 
 import (
+	"context"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"net"
-	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/jlmucb/cloudproxy/go/tao"
 	"github.com/jlmucb/cloudproxy/go/util"
 	"github.com/jlmucb/cloudproxy/go/util/options"
 	"github.com/jlmucb/cloudproxy/go/util/verbose"
-	"github.com/kevinawalsh/taoca/rendezvous"
 	"github.com/kevinawalsh/taoca/util/x509txt"
 )
 
@@ -56,86 +57,475 @@ func NewCertificateSigningRequest(key *tao.Verifier, name *pkix.Name) *CSR {
 
 var DefaultServerName = "https ca"
 var Warn = true
+
+// DefaultServer, if set, is used as the default certificate authority server,
+// instead of the replicas found by DefaultClient.
 var DefaultServer *Server
-var defaultServerErr error
-var once sync.Once
+
+// GetDefaultServer returns the default certificate authority server. Unless
+// DefaultServer is set, this is a replica located using rendezvous lookup for
+// DefaultServerName, preferring replicas that have not recently failed, and
+// checking that it accepts connections. See Client.
+func GetDefaultServer() (*Server, error) {
+	if DefaultServer != nil {
+		return DefaultServer, nil
+	}
+	return DefaultClient.Server()
+}
 
 // Submit sends a CSR to the default certificate authority server, which is
 // located using rendezvous lookup for "https ca". The keys are used to
 // authenticate to the server.
-func GetDefaultServer() (*Server, error) {
-	once.Do(func() {
-		if DefaultServer != nil {
-			return
+func Submit(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
+	return defaultClient().Submit(keys, csr)
+}
+
+// SubmitContext is like Submit, but it gives up when ctx is done. See
+// Server.SubmitContext.
+func SubmitContext(ctx context.Context, keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
+	return defaultClient().SubmitContext(ctx, keys, csr)
+}
+
+// SubmitPKCS10 sends a DER-encoded PKCS#10 certificate request to the default
+// certificate authority server. The keys are used to authenticate to the
+// server.
+func SubmitPKCS10(keys *tao.Keys, der []byte) ([]*x509.Certificate, error) {
+	return defaultClient().SubmitPKCS10(keys, der)
+}
+
+// SubmitAsync sends a CSR to the default certificate authority server, without
+// waiting for manual approval. See Server.SubmitAsync.
+func SubmitAsync(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, string, error) {
+	return defaultClient().SubmitAsync(keys, csr)
+}
+
+// Poll asks the default certificate authority server for the outcome of a
+// request queued for manual approval. See Server.Poll.
+func Poll(keys *tao.Keys, id string, wait bool) ([]*x509.Certificate, error) {
+	return defaultClient().Poll(keys, id, wait)
+}
+
+// Revoke asks the default certificate authority server to revoke a certificate.
+// The keys are used to authenticate to the server.
+func Revoke(keys *tao.Keys, serial int64, reason int) error {
+	return defaultClient().Revoke(keys, serial, reason)
+}
+
+// Timeouts for requests to certificate authority servers. A context deadline
+// that comes sooner takes precedence.
+var (
+	// DialTimeout bounds the time to connect and authenticate to a server.
+	DialTimeout = 30 * time.Second
+
+	// ReadTimeout bounds the time to send a request and receive the response.
+	// It should exceed the time a server holds a poll for a request awaiting
+	// manual approval.
+	ReadTimeout = 2 * time.Minute
+)
+
+// Retries for requests that fail because a server can't be reached.
+var (
+	// MaxAttempts limits the number of attempts to send a request.
+	MaxAttempts = 4
+
+	// RetryBackoff is the delay after the first failed attempt. The delay
+	// doubles after each further failed attempt, up to MaxRetryBackoff.
+	RetryBackoff    = time.Second
+	MaxRetryBackoff = 30 * time.Second
+
+	// MaxPollAttempts limits the number of consecutive failed attempts to poll
+	// for the decision on a request queued for manual approval, e.g. because
+	// the server has gone away or stopped answering.
+	MaxPollAttempts = 10
+)
+
+// retry calls f until it succeeds, fails with an error other than
+// UnreachableError, or has been called the given number of times, backing off
+// exponentially between attempts. If attempts is zero, there is no limit.
+func retry(ctx context.Context, attempts int, f func() error) error {
+	backoff := RetryBackoff
+	for n := 1; ; n++ {
+		err := f()
+		if !IsUnreachable(err) || (attempts > 0 && n >= attempts) {
+			return err
 		}
-		b, err := rendezvous.Lookup(DefaultServerName)
-		if err != nil {
-			defaultServerErr = err
-			return
+		verbose.Printf("Certificate authority request failed, retrying in %v: %s\n", backoff, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
 		}
-		if len(b) == 0 {
-			defaultServerErr = fmt.Errorf("no https certificate authority servers found")
-			return
+		backoff *= 2
+		if backoff > MaxRetryBackoff {
+			backoff = MaxRetryBackoff
 		}
-		DefaultServer = &Server{
-			Host: *b[0].Host,
-			Port: *b[0].Port,
+	}
+}
+
+// dial connects to a certificate authority server, giving up if ctx is done or
+// DialTimeout elapses. The keys are used to authenticate to the server.
+func (server *Server) dial(ctx context.Context, keys *tao.Keys) (*tao.Conn, error) {
+	type result struct {
+		conn *tao.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := tao.Dial("tcp", server.addr(), nil /* guard */, nil /* verifier */, keys, nil)
+		ch <- result{conn, err}
+	}()
+	timer := time.NewTimer(DialTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, &UnreachableError{server.addr(), r.err}
 		}
-	})
-	return DefaultServer, defaultServerErr
+		return r.conn, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timer.C:
+		err = &UnreachableError{server.addr(), fmt.Errorf("timed out after %v", DialTimeout)}
+	}
+	// Clean up the connection, if it is ever made.
+	go func() {
+		if r := <-ch; r.conn != nil {
+			r.conn.Close()
+		}
+	}()
+	return nil, err
 }
 
-// Submit sends a CSR to the default certificate authority server, which is
-// located using rendezvous lookup for "https ca". The keys are used to
-// authenticate to the server.
-func Submit(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
-	server, err := GetDefaultServer()
+// request sends a request to a certificate authority server and waits for a
+// successful (or pending) response. The keys are used to authenticate to the
+// server.
+func (server *Server) request(keys *tao.Keys, req *Request) (*Response, error) {
+	return server.requestContext(context.Background(), keys, req)
+}
+
+// requestContext is like request, but it gives up if ctx is done, or if
+// DialTimeout or ReadTimeout elapses.
+func (server *Server) requestContext(ctx context.Context, keys *tao.Keys, req *Request) (*Response, error) {
+	conn, err := server.dial(ctx, keys)
 	if err != nil {
 		return nil, err
 	}
-	return server.Submit(keys, csr)
+	defer conn.Close()
+
+	// Close the connection if ctx is done, to interrupt a blocked read or write.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-finished:
+		}
+	}()
+	deadline := time.Now().Add(ReadTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	ms := util.NewMessageStream(conn)
+
+	_, err = ms.WriteMessage(req)
+	if err != nil {
+		return nil, server.connError(ctx, err)
+	}
+
+	var resp Response
+	if err := ms.ReadMessage(&resp); err != nil {
+		return nil, server.connError(ctx, err)
+	}
+	if *resp.Status != ResponseStatus_TAOCA_OK && *resp.Status != ResponseStatus_TAOCA_PENDING {
+		return nil, &ResponseError{
+			Status:     resp.GetStatus(),
+			Detail:     resp.GetErrorDetail(),
+			RetryAfter: time.Duration(resp.GetRetryAfterSeconds()) * time.Second,
+		}
+	}
+	return &resp, nil
+}
+
+// connError returns the error to report for a failed connection: the context
+// error if ctx is done, and otherwise an UnreachableError.
+func (server *Server) connError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return &UnreachableError{server.addr(), err}
+}
+
+// Revoke asks a certificate authority server to revoke a certificate, using an
+// RFC 5280 CRLReason code, e.g. 1 for keyCompromise, or 0 if unspecified. The
+// keys are used to authenticate to the server, and must belong to the
+// principal that requested the certificate or to an admin principal.
+func (server *Server) Revoke(keys *tao.Keys, serial int64, reason int) error {
+	t := RequestType_TAOCA_REVOKE
+	req := &Request{
+		Type: &t,
+		Revocation: &Revocation{
+			SerialNumber: proto.Int64(serial),
+			Reason:       proto.Int32(int32(reason)),
+		},
+	}
+	_, err := server.request(keys, req)
+	return err
 }
 
 // Submit sends a CSR to a certificate authority server. The keys are used to
-// authenticate to the server.
+// authenticate to the server, and the CSR is signed using the signing key from
+// keys, as proof of possession of the key being certified. If the CSR does not
+// already carry a delegation, the one from keys, if any, is included. If the
+// request is queued for manual approval, Submit waits for the decision.
 func (server *Server) Submit(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
-	addr := net.JoinHostPort(server.Host, server.Port)
-	conn, err := tao.Dial("tcp", addr, nil /* guard */, nil /* verifier */, keys, nil)
+	return server.SubmitContext(context.Background(), keys, csr)
+}
+
+// SubmitContext is like Submit, but it gives up when ctx is done. If the server
+// can't be reached, the request is retried, up to MaxAttempts times, backing
+// off between attempts. Once a request is queued for manual approval, polling
+// for the decision is retried until ctx is done, or until MaxPollAttempts
+// consecutive polls fail, e.g. because the server has gone away or stopped
+// answering. A denial is reported as a
+// ResponseError, and a failure to reach the server as an UnreachableError.
+// Note that a request that fails after being sent may still have been carried
+// out by the server, in which case a retry duplicates it.
+func (server *Server) SubmitContext(ctx context.Context, keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
+	certs, _, err := server.submit(ctx, keys, csr)
+	return certs, err
+}
+
+// SubmitWithProof is like Submit, but it also returns the proof, if any, that
+// the issued certificate was added to the CA's log.
+func (server *Server) SubmitWithProof(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, *InclusionProof, error) {
+	return server.submit(context.Background(), keys, csr)
+}
+
+func (server *Server) submit(ctx context.Context, keys *tao.Keys, csr *CSR) (certs []*x509.Certificate, proof *InclusionProof, err error) {
+	req, err := signedRequest(keys, csr)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = retry(ctx, MaxAttempts, func() error {
+		certs, proof, err = server.sign(ctx, keys, req)
+		return err
+	})
+	return
+}
+
+// SubmitAsync is like Submit, but it does not wait for manual approval. If the
+// request is queued for approval, no certificates are returned, only the
+// request ID to use with Poll.
+func (server *Server) SubmitAsync(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, string, error) {
+	req, err := signedRequest(keys, csr)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := server.request(keys, req)
+	if err != nil {
+		return nil, "", err
+	}
+	if resp.GetStatus() == ResponseStatus_TAOCA_PENDING {
+		return nil, resp.GetRequestId(), nil
+	}
+	certs, _, err := parseChain(resp)
+	return certs, "", err
+}
+
+// ErrPending is returned by Poll if a request has not yet been decided.
+var ErrPending = errors.New("request is pending approval")
+
+// Poll asks a certificate authority server for the outcome of a request that
+// was queued for manual approval. If wait is true, the server holds the
+// request for a while in case a decision is made. If the request has still not
+// been decided, ErrPending is returned. The keys must belong to the principal
+// that made the request, or to an admin principal.
+func (server *Server) Poll(keys *tao.Keys, id string, wait bool) ([]*x509.Certificate, error) {
+	resp, err := server.poll(keys, id, wait)
 	if err != nil {
 		return nil, err
 	}
-	defer conn.Close()
-	ms := util.NewMessageStream(conn)
+	if resp.GetStatus() == ResponseStatus_TAOCA_PENDING {
+		return nil, ErrPending
+	}
+	certs, _, err := parseChain(resp)
+	return certs, err
+}
 
-	req := &Request{CSR: csr}
-	_, err = ms.WriteMessage(req)
+func (server *Server) poll(keys *tao.Keys, id string, wait bool) (*Response, error) {
+	return server.pollContext(context.Background(), keys, id, wait)
+}
+
+func (server *Server) pollContext(ctx context.Context, keys *tao.Keys, id string, wait bool) (*Response, error) {
+	t := RequestType_TAOCA_POLL
+	req := &Request{
+		Type:      &t,
+		RequestId: proto.String(id),
+		Wait:      proto.Bool(wait),
+	}
+	return server.requestContext(ctx, keys, req)
+}
+
+// ListPending asks a certificate authority server for the requests awaiting
+// manual approval. The keys must belong to an admin principal.
+func (server *Server) ListPending(keys *tao.Keys) ([]*PendingRequest, error) {
+	t := RequestType_TAOCA_LIST_PENDING
+	resp, err := server.request(keys, &Request{Type: &t})
 	if err != nil {
 		return nil, err
 	}
+	return resp.Pending, nil
+}
 
-	var resp Response
-	if err := ms.ReadMessage(&resp); err != nil {
+// Decide asks a certificate authority server to approve or deny a request
+// awaiting manual approval. The keys must belong to an admin principal.
+func (server *Server) Decide(keys *tao.Keys, id string, approve bool) error {
+	t := RequestType_TAOCA_DECIDE
+	req := &Request{
+		Type:      &t,
+		RequestId: proto.String(id),
+		Approve:   proto.Bool(approve),
+	}
+	_, err := server.request(keys, req)
+	return err
+}
+
+// signedRequest wraps a CSR, with a delegation and proof of possession, in a
+// signing request. The CSR is sent serialized, exactly as signed.
+func signedRequest(keys *tao.Keys, csr *CSR) (*Request, error) {
+	if csr.Delegation == nil && keys.Delegation != nil {
+		d, err := proto.Marshal(keys.Delegation)
+		if err != nil {
+			return nil, err
+		}
+		csr.Delegation = d
+	}
+	data, sig, err := SignCSR(keys.SigningKey, csr)
+	if err != nil {
 		return nil, err
 	}
-	if *resp.Status != ResponseStatus_TAOCA_OK {
-		detail := "unknown error"
-		if resp.ErrorDetail != nil {
-			detail = *resp.ErrorDetail
+	return &Request{SerializedCsr: data, Signature: sig}, nil
+}
+
+// CSRSigningContext is the context used for signatures over CSRs.
+const CSRSigningContext = "TaoCA CSR Signature"
+
+// SignCSR serializes and signs a CSR. The serialized CSR must be sent along with
+// the signature, since a CSR may not serialize to the same bytes everywhere.
+func SignCSR(key *tao.Signer, csr *CSR) (data, sig []byte, err error) {
+	data, err = proto.Marshal(csr)
+	if err != nil {
+		return nil, nil, err
+	}
+	sig, err = key.Sign(data, CSRSigningContext)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, sig, nil
+}
+
+// VerifyCSR checks a signature over a serialized CSR.
+func VerifyCSR(key *tao.Verifier, data, sig []byte) (bool, error) {
+	return key.Verify(data, CSRSigningContext, sig)
+}
+
+// SubmitPKCS10 sends a DER-encoded PKCS#10 certificate request to a
+// certificate authority server. The keys are used to authenticate to the
+// server, and need not match the key in the certificate request.
+func (server *Server) SubmitPKCS10(keys *tao.Keys, der []byte) (certs []*x509.Certificate, err error) {
+	ctx := context.Background()
+	err = retry(ctx, MaxAttempts, func() error {
+		certs, _, err = server.sign(ctx, keys, &Request{Pkcs10: der})
+		return err
+	})
+	return
+}
+
+// sign sends a signing request to a certificate authority server, waiting for
+// manual approval if needed, and parses the resulting certificate chain and
+// inclusion proof.
+func (server *Server) sign(ctx context.Context, keys *tao.Keys, req *Request) ([]*x509.Certificate, *InclusionProof, error) {
+	resp, err := server.requestContext(ctx, keys, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.GetStatus() == ResponseStatus_TAOCA_PENDING {
+		id := resp.GetRequestId()
+		verbose.Printf("Request %s is pending approval, waiting...\n", id)
+		resp, err = waitForDecision(ctx, func() (*Response, error) {
+			return server.pollContext(ctx, keys, id, true)
+		})
+		if IsUnreachable(err) {
+			// Don't send the request again, since it is still queued.
+			return nil, nil, fmt.Errorf("gave up waiting for decision on request %s: %s", id, err)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return parseChain(resp)
+}
+
+// waitForDecision calls poll until the response is no longer pending. Failed
+// polls are retried until ctx is done, or until MaxPollAttempts consecutive
+// polls fail.
+func waitForDecision(ctx context.Context, poll func() (*Response, error)) (resp *Response, err error) {
+	for {
+		err = retry(ctx, MaxPollAttempts, func() error {
+			resp, err = poll()
+			return err
+		})
+		if err != nil || resp.GetStatus() != ResponseStatus_TAOCA_PENDING {
+			return
 		}
-		return nil, fmt.Errorf("%s: %s", resp.Status, detail)
 	}
+}
+
+// parseChain parses the certificate chain and inclusion proof from a response.
+func parseChain(resp *Response) ([]*x509.Certificate, *InclusionProof, error) {
 	if len(resp.Cert) == 0 {
-		return nil, fmt.Errorf("no certificates in CA response")
+		return nil, nil, fmt.Errorf("no certificates in CA response")
 	}
 	certs := make([]*x509.Certificate, len(resp.Cert))
 	for i, c := range resp.Cert {
 		cert, err := x509.ParseCertificate(c.X509Cert)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		certs[i] = cert
 	}
-	return certs, nil
+	if resp.Inclusion != nil && !VerifyInclusion(certs[0], resp.Inclusion) {
+		return nil, nil, fmt.Errorf("bad inclusion proof in CA response")
+	}
+	return certs, resp.Inclusion, nil
+}
+
+// SetLifetime sets the requested validity period for a CSR. The years field is
+// also set, rounded up, for servers that do not support shorter periods.
+func (m *CSR) SetLifetime(d time.Duration) {
+	year := 365 * 24 * time.Hour
+	m.Years = proto.Int32(int32((d + year - 1) / year))
+	m.ValiditySeconds = proto.Int64(int64(d / time.Second))
+}
+
+// Lifetime returns the requested validity period for a CSR.
+func (m *CSR) Lifetime() time.Duration {
+	if m.ValiditySeconds != nil {
+		return time.Duration(m.GetValiditySeconds()) * time.Second
+	}
+	return time.Duration(m.GetYears()) * 365 * 24 * time.Hour
+}
+
+// AddAltName adds a DNS name or IP address to the subject alternative names.
+func (m *X509Details) AddAltName(host string) {
+	if ip := net.ParseIP(host); ip != nil {
+		m.IpAddress = append(m.IpAddress, ip.String())
+	} else {
+		m.DnsName = append(m.DnsName, host)
+	}
 }
 
 func ConfirmName(n *pkix.Name) *pkix.Name {
@@ -154,7 +544,8 @@ var ConfirmNames = true
 // GenerateKeys initializes a new tls key, confirms certificate details with the
 // user, obtains a signed certificate from the default ca, and stores the
 // resulting keys and certificates in kdir. This is meant to be called from
-// user-facing apps.
+// user-facing apps. The key is an ECDSA P-256 key, the only kind the Tao key
+// layer generates.
 func GenerateKeys(name *pkix.Name, addr, kdir string) *tao.Keys {
 	host, _, err := net.SplitHostPort(addr)
 	options.FailIf(err, "bad address: %s", addr)
@@ -173,6 +564,7 @@ func GenerateKeys(name *pkix.Name, addr, kdir string) *tao.Keys {
 	options.FailIf(err, "can't create tao-sealed HTTPS/TLS keys")
 
 	csr := NewCertificateSigningRequest(keys.VerifyingKey, name)
+	csr.Name.AddAltName(host)
 
 	SubmitAndInstall(keys, csr)
 	return keys
@@ -185,18 +577,7 @@ func SubmitAndInstall(keys *tao.Keys, csr *CSR) {
 	if len(resp) == 0 {
 		options.Fail(nil, "no x509 certificates returned from CA")
 	}
-	// Add the certs to our keys...
-	keys.Cert["default"] = resp[0]
-	for i, c := range resp {
-		name := "ca"
-		if i > 0 {
-			name = fmt.Sprintf("ca-%d", i)
-		}
-		keys.Cert[name] = c
-	}
-	if keys.X509Path("default") != "" {
-		err = keys.SaveCerts()
-	}
+	err = installCerts(keys, resp)
 	options.FailIf(err, "can't save X509 certificates")
 
 	chain := keys.CertChain("default")
@@ -209,6 +590,30 @@ func SubmitAndInstall(keys *tao.Keys, csr *CSR) {
 	}
 }
 
+// installCerts adds a certificate chain obtained from the CA to keys, and saves
+// the certificates if the keys are stored on disk. The certificate map is
+// replaced rather than modified in place, since it may be shared with running
+// handlers, e.g. https.CertificateHandler.
+func installCerts(keys *tao.Keys, certs []*x509.Certificate) error {
+	m := make(map[string]*x509.Certificate)
+	for name, c := range keys.Cert {
+		m[name] = c
+	}
+	m["default"] = certs[0]
+	for i, c := range certs {
+		name := "ca"
+		if i > 0 {
+			name = fmt.Sprintf("ca-%d", i)
+		}
+		m[name] = c
+	}
+	keys.Cert = m
+	if keys.X509Path("default") != "" {
+		return keys.SaveCerts()
+	}
+	return nil
+}
+
 // LoadKeys loads and https key and cert from a directory. This is meant to be
 // called from user-facing apps.
 func LoadKeys(kdir string) *tao.Keys {
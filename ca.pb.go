@@ -10,7 +10,12 @@ It is generated from these files:
 It has these top-level messages:
 	X509Details
 	CSR
+	Revocation
+	TreeHead
+	InclusionProof
+	ConsistencyQuery
 	Request
+	PendingRequest
 	Cert
 	Response
 */
@@ -31,6 +36,52 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
+type RequestType int32
+
+const (
+	RequestType_TAOCA_SIGN            RequestType = 0
+	RequestType_TAOCA_REVOKE          RequestType = 1
+	RequestType_TAOCA_LOG_CONSISTENCY RequestType = 2
+	RequestType_TAOCA_POLL            RequestType = 3
+	RequestType_TAOCA_LIST_PENDING    RequestType = 4
+	RequestType_TAOCA_DECIDE          RequestType = 5
+)
+
+var RequestType_name = map[int32]string{
+	0: "TAOCA_SIGN",
+	1: "TAOCA_REVOKE",
+	2: "TAOCA_LOG_CONSISTENCY",
+	3: "TAOCA_POLL",
+	4: "TAOCA_LIST_PENDING",
+	5: "TAOCA_DECIDE",
+}
+var RequestType_value = map[string]int32{
+	"TAOCA_SIGN":            0,
+	"TAOCA_REVOKE":          1,
+	"TAOCA_LOG_CONSISTENCY": 2,
+	"TAOCA_POLL":            3,
+	"TAOCA_LIST_PENDING":    4,
+	"TAOCA_DECIDE":          5,
+}
+
+func (x RequestType) Enum() *RequestType {
+	p := new(RequestType)
+	*p = x
+	return p
+}
+func (x RequestType) String() string {
+	return proto.EnumName(RequestType_name, int32(x))
+}
+func (x *RequestType) UnmarshalJSON(data []byte) error {
+	value, err := proto.UnmarshalJSONEnum(RequestType_value, data, "RequestType")
+	if err != nil {
+		return err
+	}
+	*x = RequestType(value)
+	return nil
+}
+func (RequestType) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+
 type ResponseStatus int32
 
 const (
@@ -38,6 +89,9 @@ const (
 	ResponseStatus_TAOCA_BAD_REQUEST    ResponseStatus = 1
 	ResponseStatus_TAOCA_REQUEST_DENIED ResponseStatus = 2
 	ResponseStatus_TAOCA_ERROR          ResponseStatus = 3
+	ResponseStatus_TAOCA_BAD_SIGNATURE  ResponseStatus = 4
+	ResponseStatus_TAOCA_PENDING        ResponseStatus = 5
+	ResponseStatus_TAOCA_RATE_LIMITED   ResponseStatus = 6
 )
 
 var ResponseStatus_name = map[int32]string{
@@ -45,12 +99,18 @@ var ResponseStatus_name = map[int32]string{
 	1: "TAOCA_BAD_REQUEST",
 	2: "TAOCA_REQUEST_DENIED",
 	3: "TAOCA_ERROR",
+	4: "TAOCA_BAD_SIGNATURE",
+	5: "TAOCA_PENDING",
+	6: "TAOCA_RATE_LIMITED",
 }
 var ResponseStatus_value = map[string]int32{
 	"TAOCA_OK":             0,
 	"TAOCA_BAD_REQUEST":    1,
 	"TAOCA_REQUEST_DENIED": 2,
 	"TAOCA_ERROR":          3,
+	"TAOCA_BAD_SIGNATURE":  4,
+	"TAOCA_PENDING":        5,
+	"TAOCA_RATE_LIMITED":   6,
 }
 
 func (x ResponseStatus) Enum() *ResponseStatus {
@@ -69,7 +129,7 @@ func (x *ResponseStatus) UnmarshalJSON(data []byte) error {
 	*x = ResponseStatus(value)
 	return nil
 }
-func (ResponseStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{0} }
+func (ResponseStatus) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{1} }
 
 type X509Details struct {
 	CommonName         *string `protobuf:"bytes,1,opt,name=common_name" json:"common_name,omitempty"`
@@ -79,7 +139,11 @@ type X509Details struct {
 	Organization       *string `protobuf:"bytes,5,opt,name=organization" json:"organization,omitempty"`
 	OrganizationalUnit *string `protobuf:"bytes,6,opt,name=organizational_unit" json:"organizational_unit,omitempty"`
 	SerialNumber       *int32  `protobuf:"varint,7,opt,name=serial_number" json:"serial_number,omitempty"`
-	XXX_unrecognized   []byte  `json:"-"`
+	// Subject alternative names.
+	DnsName          []string `protobuf:"bytes,8,rep,name=dns_name" json:"dns_name,omitempty"`
+	IpAddress        []string `protobuf:"bytes,9,rep,name=ip_address" json:"ip_address,omitempty"`
+	Uri              []string `protobuf:"bytes,10,rep,name=uri" json:"uri,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
 }
 
 func (m *X509Details) Reset()                    { *m = X509Details{} }
@@ -136,17 +200,48 @@ func (m *X509Details) GetSerialNumber() int32 {
 	return 0
 }
 
+func (m *X509Details) GetDnsName() []string {
+	if m != nil {
+		return m.DnsName
+	}
+	return nil
+}
+
+func (m *X509Details) GetIpAddress() []string {
+	if m != nil {
+		return m.IpAddress
+	}
+	return nil
+}
+
+func (m *X509Details) GetUri() []string {
+	if m != nil {
+		return m.Uri
+	}
+	return nil
+}
+
 type CSR struct {
 	// Public key for the certificate being requested, as a serialized
 	// tao.CryptoKey.
 	PublicKey []byte `protobuf:"bytes,1,req,name=public_key" json:"public_key,omitempty"`
 	// Subject name details for the certificate being requested.
 	Name *X509Details `protobuf:"bytes,2,req,name=name" json:"name,omitempty"`
-	// Requested duration for the certificate being requested.
+	// Requested duration for the certificate being requested. Ignored if
+	// validity_seconds is present.
 	Years *int32 `protobuf:"varint,3,req,name=years" json:"years,omitempty"`
 	// Whether the certificate being requested should have the IsCA flag set.
-	IsCa             *bool  `protobuf:"varint,4,req,name=is_ca" json:"is_ca,omitempty"`
-	XXX_unrecognized []byte `json:"-"`
+	IsCa *bool `protobuf:"varint,4,req,name=is_ca" json:"is_ca,omitempty"`
+	// A serialized tao.Attestation stating that the public key speaks for the
+	// requesting principal, e.g. the Delegation from a tao.Keys.
+	Delegation []byte `protobuf:"bytes,5,opt,name=delegation" json:"delegation,omitempty"`
+	// Requested duration for the certificate being requested, in seconds. This
+	// allows for validity periods shorter than a year.
+	ValiditySeconds *int64 `protobuf:"varint,6,opt,name=validity_seconds" json:"validity_seconds,omitempty"`
+	// Name of the certificate profile to use, e.g. "server", "client",
+	// "codesigning", or "ca". If absent, the CA's default settings are used.
+	Profile          *string `protobuf:"bytes,7,opt,name=profile" json:"profile,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
 }
 
 func (m *CSR) Reset()                    { *m = CSR{} }
@@ -182,16 +277,192 @@ func (m *CSR) GetIsCa() bool {
 	return false
 }
 
+func (m *CSR) GetDelegation() []byte {
+	if m != nil {
+		return m.Delegation
+	}
+	return nil
+}
+
+func (m *CSR) GetValiditySeconds() int64 {
+	if m != nil && m.ValiditySeconds != nil {
+		return *m.ValiditySeconds
+	}
+	return 0
+}
+
+func (m *CSR) GetProfile() string {
+	if m != nil && m.Profile != nil {
+		return *m.Profile
+	}
+	return ""
+}
+
+type Revocation struct {
+	// Serial number of the certificate to be revoked.
+	SerialNumber *int64 `protobuf:"varint,1,req,name=serial_number" json:"serial_number,omitempty"`
+	// Reason for revocation, as an RFC 5280 CRLReason code.
+	Reason           *int32 `protobuf:"varint,2,opt,name=reason" json:"reason,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *Revocation) Reset()                    { *m = Revocation{} }
+func (m *Revocation) String() string            { return proto.CompactTextString(m) }
+func (*Revocation) ProtoMessage()               {}
+func (*Revocation) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *Revocation) GetSerialNumber() int64 {
+	if m != nil && m.SerialNumber != nil {
+		return *m.SerialNumber
+	}
+	return 0
+}
+
+func (m *Revocation) GetReason() int32 {
+	if m != nil && m.Reason != nil {
+		return *m.Reason
+	}
+	return 0
+}
+
+// A signed tree head for the log of issued certificates.
+type TreeHead struct {
+	// Number of entries in the log.
+	TreeSize *int64 `protobuf:"varint,1,req,name=tree_size" json:"tree_size,omitempty"`
+	// When the tree head was signed, in nanoseconds since the epoch.
+	Timestamp *int64 `protobuf:"varint,2,req,name=timestamp" json:"timestamp,omitempty"`
+	// Merkle tree hash of the log entries.
+	RootHash []byte `protobuf:"bytes,3,req,name=root_hash" json:"root_hash,omitempty"`
+	// CA signature over the serialized tree head, without this field.
+	Signature        []byte `protobuf:"bytes,4,opt,name=signature" json:"signature,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *TreeHead) Reset()                    { *m = TreeHead{} }
+func (m *TreeHead) String() string            { return proto.CompactTextString(m) }
+func (*TreeHead) ProtoMessage()               {}
+func (*TreeHead) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+
+func (m *TreeHead) GetTreeSize() int64 {
+	if m != nil && m.TreeSize != nil {
+		return *m.TreeSize
+	}
+	return 0
+}
+
+func (m *TreeHead) GetTimestamp() int64 {
+	if m != nil && m.Timestamp != nil {
+		return *m.Timestamp
+	}
+	return 0
+}
+
+func (m *TreeHead) GetRootHash() []byte {
+	if m != nil {
+		return m.RootHash
+	}
+	return nil
+}
+
+func (m *TreeHead) GetSignature() []byte {
+	if m != nil {
+		return m.Signature
+	}
+	return nil
+}
+
+// Proof that an issued certificate is included in the log.
+type InclusionProof struct {
+	LeafIndex        *int64    `protobuf:"varint,1,req,name=leaf_index" json:"leaf_index,omitempty"`
+	TreeHead         *TreeHead `protobuf:"bytes,2,req,name=tree_head" json:"tree_head,omitempty"`
+	AuditPath        [][]byte  `protobuf:"bytes,3,rep,name=audit_path" json:"audit_path,omitempty"`
+	XXX_unrecognized []byte    `json:"-"`
+}
+
+func (m *InclusionProof) Reset()                    { *m = InclusionProof{} }
+func (m *InclusionProof) String() string            { return proto.CompactTextString(m) }
+func (*InclusionProof) ProtoMessage()               {}
+func (*InclusionProof) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+
+func (m *InclusionProof) GetLeafIndex() int64 {
+	if m != nil && m.LeafIndex != nil {
+		return *m.LeafIndex
+	}
+	return 0
+}
+
+func (m *InclusionProof) GetTreeHead() *TreeHead {
+	if m != nil {
+		return m.TreeHead
+	}
+	return nil
+}
+
+func (m *InclusionProof) GetAuditPath() [][]byte {
+	if m != nil {
+		return m.AuditPath
+	}
+	return nil
+}
+
+type ConsistencyQuery struct {
+	// Size of the earlier tree.
+	First *int64 `protobuf:"varint,1,req,name=first" json:"first,omitempty"`
+	// Size of the later tree, or zero for the latest signed tree head. The CA
+	// must already have signed a tree head of this size.
+	Second           *int64 `protobuf:"varint,2,opt,name=second" json:"second,omitempty"`
+	XXX_unrecognized []byte `json:"-"`
+}
+
+func (m *ConsistencyQuery) Reset()                    { *m = ConsistencyQuery{} }
+func (m *ConsistencyQuery) String() string            { return proto.CompactTextString(m) }
+func (*ConsistencyQuery) ProtoMessage()               {}
+func (*ConsistencyQuery) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{5} }
+
+func (m *ConsistencyQuery) GetFirst() int64 {
+	if m != nil && m.First != nil {
+		return *m.First
+	}
+	return 0
+}
+
+func (m *ConsistencyQuery) GetSecond() int64 {
+	if m != nil && m.Second != nil {
+		return *m.Second
+	}
+	return 0
+}
+
 type Request struct {
-	CSR              *CSR   `protobuf:"bytes,1,req,name=CSR" json:"CSR,omitempty"`
-	Signature        []byte `protobuf:"bytes,2,opt,name=signature" json:"signature,omitempty"`
+	// The CSR, for TAOCA_SIGN requests made without a proof of possession.
+	// Signed requests carry serialized_csr instead.
+	CSR *CSR `protobuf:"bytes,1,opt,name=CSR" json:"CSR,omitempty"`
+	// Signature over serialized_csr, made with the key being certified.
+	Signature []byte       `protobuf:"bytes,2,opt,name=signature" json:"signature,omitempty"`
+	Type      *RequestType `protobuf:"varint,3,opt,name=type,enum=taoca.RequestType" json:"type,omitempty"`
+	// The certificate to be revoked, for TAOCA_REVOKE requests.
+	Revocation *Revocation `protobuf:"bytes,4,opt,name=revocation" json:"revocation,omitempty"`
+	// A DER-encoded PKCS#10 CertificationRequest, for TAOCA_SIGN requests, as
+	// an alternative to CSR.
+	Pkcs10 []byte `protobuf:"bytes,5,opt,name=pkcs10" json:"pkcs10,omitempty"`
+	// The tree sizes, for TAOCA_LOG_CONSISTENCY requests.
+	Consistency *ConsistencyQuery `protobuf:"bytes,6,opt,name=consistency" json:"consistency,omitempty"`
+	// The pending request, for TAOCA_POLL and TAOCA_DECIDE requests.
+	RequestId *string `protobuf:"bytes,7,opt,name=request_id" json:"request_id,omitempty"`
+	// Whether to wait a while for a decision, for TAOCA_POLL requests.
+	Wait *bool `protobuf:"varint,8,opt,name=wait" json:"wait,omitempty"`
+	// The decision, for TAOCA_DECIDE requests.
+	Approve *bool `protobuf:"varint,9,opt,name=approve" json:"approve,omitempty"`
+	// The serialized CSR, for TAOCA_SIGN requests. The signature is checked
+	// over exactly these bytes, which are parsed in place of CSR.
+	SerializedCsr    []byte `protobuf:"bytes,10,opt,name=serialized_csr" json:"serialized_csr,omitempty"`
 	XXX_unrecognized []byte `json:"-"`
 }
 
 func (m *Request) Reset()                    { *m = Request{} }
 func (m *Request) String() string            { return proto.CompactTextString(m) }
 func (*Request) ProtoMessage()               {}
-func (*Request) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*Request) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{6} }
 
 func (m *Request) GetCSR() *CSR {
 	if m != nil {
@@ -207,6 +478,89 @@ func (m *Request) GetSignature() []byte {
 	return nil
 }
 
+func (m *Request) GetType() RequestType {
+	if m != nil && m.Type != nil {
+		return *m.Type
+	}
+	return RequestType_TAOCA_SIGN
+}
+
+func (m *Request) GetRevocation() *Revocation {
+	if m != nil {
+		return m.Revocation
+	}
+	return nil
+}
+
+func (m *Request) GetPkcs10() []byte {
+	if m != nil {
+		return m.Pkcs10
+	}
+	return nil
+}
+
+func (m *Request) GetConsistency() *ConsistencyQuery {
+	if m != nil {
+		return m.Consistency
+	}
+	return nil
+}
+
+func (m *Request) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *Request) GetWait() bool {
+	if m != nil && m.Wait != nil {
+		return *m.Wait
+	}
+	return false
+}
+
+func (m *Request) GetApprove() bool {
+	if m != nil && m.Approve != nil {
+		return *m.Approve
+	}
+	return false
+}
+
+func (m *Request) GetSerializedCsr() []byte {
+	if m != nil {
+		return m.SerializedCsr
+	}
+	return nil
+}
+
+// A CSR awaiting manual approval.
+type PendingRequest struct {
+	RequestId *string `protobuf:"bytes,1,req,name=request_id" json:"request_id,omitempty"`
+	// Human-readable description of the request.
+	Details          *string `protobuf:"bytes,2,opt,name=details" json:"details,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *PendingRequest) Reset()                    { *m = PendingRequest{} }
+func (m *PendingRequest) String() string            { return proto.CompactTextString(m) }
+func (*PendingRequest) ProtoMessage()               {}
+func (*PendingRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{7} }
+
+func (m *PendingRequest) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *PendingRequest) GetDetails() string {
+	if m != nil && m.Details != nil {
+		return *m.Details
+	}
+	return ""
+}
+
 type Cert struct {
 	X509Cert         []byte `protobuf:"bytes,1,opt,name=x509_cert" json:"x509_cert,omitempty"`
 	XXX_unrecognized []byte `json:"-"`
@@ -215,7 +569,7 @@ type Cert struct {
 func (m *Cert) Reset()                    { *m = Cert{} }
 func (m *Cert) String() string            { return proto.CompactTextString(m) }
 func (*Cert) ProtoMessage()               {}
-func (*Cert) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*Cert) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
 
 func (m *Cert) GetX509Cert() []byte {
 	if m != nil {
@@ -225,16 +579,27 @@ func (m *Cert) GetX509Cert() []byte {
 }
 
 type Response struct {
-	Status           *ResponseStatus `protobuf:"varint,1,req,name=status,enum=taoca.ResponseStatus" json:"status,omitempty"`
-	ErrorDetail      *string         `protobuf:"bytes,2,opt,name=error_detail" json:"error_detail,omitempty"`
-	Cert             []*Cert         `protobuf:"bytes,3,rep,name=cert" json:"cert,omitempty"`
-	XXX_unrecognized []byte          `json:"-"`
+	Status      *ResponseStatus `protobuf:"varint,1,req,name=status,enum=taoca.ResponseStatus" json:"status,omitempty"`
+	ErrorDetail *string         `protobuf:"bytes,2,opt,name=error_detail" json:"error_detail,omitempty"`
+	Cert        []*Cert         `protobuf:"bytes,3,rep,name=cert" json:"cert,omitempty"`
+	// Proof that the issued certificate was logged.
+	Inclusion *InclusionProof `protobuf:"bytes,4,opt,name=inclusion" json:"inclusion,omitempty"`
+	// The later tree head and the proof, for TAOCA_LOG_CONSISTENCY requests.
+	TreeHead         *TreeHead `protobuf:"bytes,5,opt,name=tree_head" json:"tree_head,omitempty"`
+	ConsistencyProof [][]byte  `protobuf:"bytes,6,rep,name=consistency_proof" json:"consistency_proof,omitempty"`
+	// The request awaiting manual approval, for TAOCA_PENDING responses.
+	RequestId *string `protobuf:"bytes,7,opt,name=request_id" json:"request_id,omitempty"`
+	// The requests awaiting manual approval, for TAOCA_LIST_PENDING requests.
+	Pending []*PendingRequest `protobuf:"bytes,8,rep,name=pending" json:"pending,omitempty"`
+	// How long to wait before trying again, for TAOCA_RATE_LIMITED responses.
+	RetryAfterSeconds *int64 `protobuf:"varint,9,opt,name=retry_after_seconds" json:"retry_after_seconds,omitempty"`
+	XXX_unrecognized  []byte `json:"-"`
 }
 
 func (m *Response) Reset()                    { *m = Response{} }
 func (m *Response) String() string            { return proto.CompactTextString(m) }
 func (*Response) ProtoMessage()               {}
-func (*Response) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
+func (*Response) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
 
 func (m *Response) GetStatus() ResponseStatus {
 	if m != nil && m.Status != nil {
@@ -257,42 +622,123 @@ func (m *Response) GetCert() []*Cert {
 	return nil
 }
 
+func (m *Response) GetInclusion() *InclusionProof {
+	if m != nil {
+		return m.Inclusion
+	}
+	return nil
+}
+
+func (m *Response) GetTreeHead() *TreeHead {
+	if m != nil {
+		return m.TreeHead
+	}
+	return nil
+}
+
+func (m *Response) GetConsistencyProof() [][]byte {
+	if m != nil {
+		return m.ConsistencyProof
+	}
+	return nil
+}
+
+func (m *Response) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *Response) GetPending() []*PendingRequest {
+	if m != nil {
+		return m.Pending
+	}
+	return nil
+}
+
+func (m *Response) GetRetryAfterSeconds() int64 {
+	if m != nil && m.RetryAfterSeconds != nil {
+		return *m.RetryAfterSeconds
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterType((*X509Details)(nil), "taoca.X509Details")
 	proto.RegisterType((*CSR)(nil), "taoca.CSR")
+	proto.RegisterType((*Revocation)(nil), "taoca.Revocation")
+	proto.RegisterType((*TreeHead)(nil), "taoca.TreeHead")
+	proto.RegisterType((*InclusionProof)(nil), "taoca.InclusionProof")
+	proto.RegisterType((*ConsistencyQuery)(nil), "taoca.ConsistencyQuery")
 	proto.RegisterType((*Request)(nil), "taoca.Request")
+	proto.RegisterType((*PendingRequest)(nil), "taoca.PendingRequest")
 	proto.RegisterType((*Cert)(nil), "taoca.Cert")
 	proto.RegisterType((*Response)(nil), "taoca.Response")
+	proto.RegisterEnum("taoca.RequestType", RequestType_name, RequestType_value)
 	proto.RegisterEnum("taoca.ResponseStatus", ResponseStatus_name, ResponseStatus_value)
 }
 
 func init() { proto.RegisterFile("ca.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 397 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x5c, 0x90, 0x4f, 0x6b, 0x1b, 0x31,
-	0x14, 0xc4, 0xbb, 0xff, 0x62, 0xe7, 0xed, 0x3a, 0x71, 0x94, 0x98, 0x2a, 0xf4, 0x22, 0x16, 0x0a,
-	0x4b, 0x0f, 0x26, 0x18, 0x7c, 0xc8, 0xd1, 0xb5, 0xf7, 0x50, 0x0a, 0x35, 0x5d, 0xa7, 0xd0, 0x9e,
-	0x54, 0x59, 0x7d, 0x04, 0x51, 0x5b, 0x72, 0x25, 0x2d, 0xd4, 0xfd, 0x28, 0xfd, 0xb4, 0x65, 0xe5,
-	0x0d, 0x98, 0x1e, 0x67, 0x46, 0xfc, 0xf4, 0x66, 0x60, 0x28, 0xc5, 0xf4, 0x60, 0x8d, 0x37, 0x24,
-	0xf3, 0xc2, 0x48, 0x51, 0xfe, 0x8d, 0x20, 0xff, 0x3a, 0x7f, 0x78, 0x5c, 0xa1, 0x17, 0x6a, 0xe7,
-	0xc8, 0x2d, 0xe4, 0xd2, 0xec, 0xf7, 0x46, 0x73, 0x2d, 0xf6, 0x48, 0x23, 0x16, 0x55, 0x97, 0xe4,
-	0x1a, 0x06, 0xd2, 0xb4, 0xda, 0xdb, 0x23, 0x8d, 0x83, 0x31, 0x82, 0xcc, 0x79, 0xe1, 0x91, 0x26,
-	0x41, 0x16, 0x90, 0x4a, 0xe5, 0x8f, 0x34, 0x0d, 0xea, 0x0e, 0x0a, 0x63, 0x9f, 0x85, 0x56, 0x7f,
-	0x84, 0x57, 0x46, 0xd3, 0x2c, 0xb8, 0x6f, 0xe0, 0xf6, 0xdc, 0x15, 0x3b, 0xde, 0x6a, 0xe5, 0xe9,
-	0x45, 0x08, 0x27, 0x30, 0x72, 0x68, 0x95, 0xd8, 0x71, 0xdd, 0xee, 0xb7, 0x68, 0xe9, 0x80, 0x45,
-	0x55, 0x56, 0x7e, 0x83, 0x64, 0xb9, 0x69, 0x08, 0x01, 0x38, 0xb4, 0xdb, 0x9d, 0x92, 0xfc, 0x27,
-	0x1e, 0x69, 0xc4, 0xe2, 0xaa, 0x20, 0x0c, 0xd2, 0x70, 0x60, 0xcc, 0xe2, 0x2a, 0x9f, 0x91, 0x69,
-	0x68, 0x33, 0x3d, 0x6f, 0x32, 0x82, 0xec, 0x88, 0xc2, 0x3a, 0x9a, 0xb0, 0xb8, 0xca, 0x3a, 0xa9,
-	0x1c, 0x97, 0x82, 0xa6, 0x2c, 0xae, 0x86, 0xe5, 0x1c, 0x06, 0x0d, 0xfe, 0x6a, 0xd1, 0x79, 0xf2,
-	0x3a, 0xfc, 0x12, 0xb8, 0xf9, 0x0c, 0x7a, 0x52, 0xf7, 0xef, 0x0d, 0x5c, 0x3a, 0xf5, 0xac, 0x85,
-	0x6f, 0x2d, 0x86, 0xe2, 0x45, 0x79, 0x0f, 0xe9, 0x12, 0xad, 0xef, 0xa2, 0xdf, 0xf3, 0x87, 0x47,
-	0x2e, 0xd1, 0xfa, 0x30, 0x52, 0x51, 0x6e, 0x61, 0xd8, 0xa0, 0x3b, 0x18, 0xed, 0x90, 0xbc, 0x85,
-	0x8b, 0x6e, 0x9f, 0xd6, 0x05, 0xea, 0xd5, 0x6c, 0xd2, 0x53, 0x5f, 0x1e, 0x6c, 0x42, 0xd8, 0x2d,
-	0x85, 0xd6, 0x1a, 0xcb, 0x7f, 0x84, 0x9b, 0xfb, 0x71, 0xef, 0x21, 0x0d, 0xd8, 0x84, 0x25, 0x55,
-	0x3e, 0xcb, 0x5f, 0x0e, 0x42, 0xeb, 0xdf, 0x7d, 0x87, 0xab, 0xff, 0x10, 0x05, 0x0c, 0x9f, 0x16,
-	0xeb, 0xe5, 0x82, 0xaf, 0x3f, 0x8e, 0x5f, 0x91, 0x09, 0xdc, 0x9c, 0xd4, 0xfb, 0xc5, 0x8a, 0x37,
-	0xf5, 0xe7, 0x2f, 0xf5, 0xe6, 0x69, 0x1c, 0x11, 0x0a, 0x77, 0x27, 0xbb, 0xb7, 0xf8, 0xaa, 0xfe,
-	0xf4, 0xa1, 0x5e, 0x8d, 0x63, 0x72, 0x0d, 0xf9, 0x29, 0xa9, 0x9b, 0x66, 0xdd, 0x8c, 0x93, 0x7f,
-	0x01, 0x00, 0x00, 0xff, 0xff, 0xaf, 0x63, 0xb3, 0x1f, 0x21, 0x02, 0x00, 0x00,
+	// 915 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x6c, 0x54, 0x5f, 0x6f, 0xdb, 0x36,
+	0x10, 0xaf, 0x24, 0x3b, 0xb1, 0x4f, 0x8e, 0xab, 0x30, 0x4d, 0xc3, 0xa0, 0x2f, 0x82, 0x81, 0x0d,
+	0x42, 0x31, 0x04, 0xa9, 0x87, 0x3c, 0xf4, 0x31, 0xb3, 0x85, 0xcc, 0x68, 0x66, 0xa7, 0xb2, 0x5b,
+	0x6c, 0xc0, 0x00, 0x82, 0x95, 0x98, 0x84, 0xa8, 0x2d, 0x6a, 0x24, 0x95, 0x55, 0x79, 0x1e, 0xb0,
+	0xaf, 0xb0, 0x0f, 0xd0, 0xaf, 0xb3, 0xef, 0x34, 0x90, 0x92, 0xed, 0x24, 0xeb, 0xe3, 0x1d, 0x4f,
+	0x77, 0xf7, 0xfb, 0x73, 0x82, 0x4e, 0x4a, 0x4f, 0x0a, 0x29, 0xb4, 0x40, 0x6d, 0x4d, 0x45, 0x4a,
+	0x07, 0xff, 0x3a, 0xe0, 0xff, 0x7a, 0x76, 0xfa, 0x76, 0xcc, 0x34, 0xe5, 0x4b, 0x85, 0x0e, 0xc0,
+	0x4f, 0xc5, 0x6a, 0x25, 0x72, 0x92, 0xd3, 0x15, 0xc3, 0x4e, 0xe8, 0x44, 0x5d, 0xf4, 0x1c, 0x76,
+	0x53, 0x51, 0xe6, 0x5a, 0x56, 0xd8, 0xb5, 0x89, 0x3d, 0x68, 0x2b, 0x4d, 0x35, 0xc3, 0x9e, 0x0d,
+	0x7b, 0xd0, 0x4a, 0xb9, 0xae, 0x70, 0xcb, 0x46, 0x2f, 0xa0, 0x27, 0xe4, 0x0d, 0xcd, 0xf9, 0x3d,
+	0xd5, 0x5c, 0xe4, 0xb8, 0x6d, 0xb3, 0xaf, 0xe0, 0xe0, 0x61, 0x96, 0x2e, 0x49, 0x99, 0x73, 0x8d,
+	0x77, 0xec, 0xe3, 0x21, 0xec, 0x29, 0x26, 0x39, 0x5d, 0x92, 0xbc, 0x5c, 0x7d, 0x62, 0x12, 0xef,
+	0x86, 0x4e, 0xd4, 0x46, 0x01, 0x74, 0xb2, 0x5c, 0xd5, 0x9b, 0x74, 0x42, 0x2f, 0xea, 0x22, 0x04,
+	0xc0, 0x0b, 0x42, 0xb3, 0x4c, 0x32, 0xa5, 0x70, 0xd7, 0xe6, 0x7c, 0xf0, 0x4a, 0xc9, 0x31, 0x98,
+	0x60, 0xf0, 0x8f, 0x03, 0xde, 0x68, 0x9e, 0x98, 0xc2, 0xa2, 0xfc, 0xb4, 0xe4, 0x29, 0xf9, 0xcc,
+	0x2a, 0xec, 0x84, 0x6e, 0xd4, 0x43, 0x21, 0xb4, 0x6c, 0x2b, 0x37, 0x74, 0x23, 0x7f, 0x88, 0x4e,
+	0x2c, 0x03, 0x27, 0x0f, 0xd1, 0xef, 0x41, 0xbb, 0x62, 0x54, 0x2a, 0xec, 0x85, 0x6e, 0xd4, 0x36,
+	0x21, 0x57, 0x24, 0xa5, 0xb8, 0x15, 0xba, 0x51, 0xc7, 0xf4, 0xcc, 0xd8, 0x92, 0xdd, 0x6c, 0x61,
+	0xf5, 0x10, 0x86, 0xe0, 0x8e, 0x2e, 0x79, 0xc6, 0x75, 0x45, 0x14, 0x4b, 0x45, 0x9e, 0x29, 0x8b,
+	0xc9, 0x33, 0xa4, 0x15, 0x52, 0x5c, 0xf3, 0x25, 0xb3, 0x68, 0xba, 0x83, 0x1f, 0x01, 0x12, 0x76,
+	0x27, 0x52, 0xfb, 0xf9, 0xff, 0x21, 0x9b, 0x1d, 0x3d, 0xd4, 0x87, 0x1d, 0xc9, 0xa8, 0x12, 0xb9,
+	0x65, 0xba, 0x3d, 0xf8, 0x08, 0x9d, 0x85, 0x64, 0xec, 0x67, 0x46, 0x33, 0xb4, 0x0f, 0x5d, 0x2d,
+	0x19, 0x23, 0x8a, 0xdf, 0xb3, 0xa6, 0xdc, 0xa4, 0xf8, 0x8a, 0x29, 0x4d, 0x57, 0x05, 0x76, 0xd7,
+	0x29, 0x29, 0x84, 0x26, 0xb7, 0x54, 0xdd, 0x5a, 0x1c, 0x3d, 0x93, 0x52, 0xfc, 0x26, 0xa7, 0xba,
+	0x94, 0xcc, 0x8a, 0xd4, 0x1b, 0xfc, 0x0e, 0xfd, 0x49, 0x9e, 0x2e, 0x4b, 0xc5, 0x45, 0x7e, 0x25,
+	0x85, 0xb8, 0x36, 0xe8, 0x96, 0x8c, 0x5e, 0x13, 0x9e, 0x67, 0xec, 0x4b, 0xd3, 0x7e, 0xd0, 0x4c,
+	0xbc, 0x65, 0x34, 0x6b, 0x68, 0x7b, 0xde, 0xd0, 0xb6, 0xd9, 0x0a, 0x01, 0xd0, 0x32, 0xe3, 0x9a,
+	0x14, 0x54, 0x9b, 0x81, 0x5e, 0xd4, 0x1b, 0xbc, 0x81, 0x60, 0x24, 0x72, 0xc5, 0x95, 0x66, 0x79,
+	0x5a, 0xbd, 0x2f, 0x99, 0xac, 0x0c, 0x99, 0xd7, 0x5c, 0x2a, 0xbd, 0x05, 0x5a, 0xf3, 0x65, 0x81,
+	0x7a, 0x83, 0xbf, 0x5d, 0xd8, 0x4d, 0xd8, 0x1f, 0x25, 0x53, 0x1a, 0x1d, 0x59, 0x0d, 0xad, 0xf9,
+	0xfc, 0x21, 0x34, 0x03, 0x8d, 0xaa, 0x8f, 0x80, 0xb8, 0x56, 0x80, 0x10, 0x5a, 0xba, 0x2a, 0x6a,
+	0x27, 0xf6, 0x37, 0xa2, 0x36, 0x9d, 0x16, 0x55, 0xc1, 0xd0, 0x77, 0x00, 0x72, 0xc3, 0xbb, 0x85,
+	0xef, 0x0f, 0xf7, 0x37, 0x75, 0xeb, 0x07, 0xb3, 0x50, 0xf1, 0x39, 0x55, 0x6f, 0x4e, 0x1b, 0x65,
+	0x7f, 0x30, 0x97, 0xb0, 0xc1, 0x60, 0x45, 0xf5, 0x87, 0x47, 0xeb, 0x65, 0x9e, 0xa2, 0x43, 0x66,
+	0x88, 0x9d, 0x49, 0x78, 0x86, 0x77, 0xd7, 0x67, 0xf1, 0x27, 0xe5, 0x1a, 0x77, 0x42, 0x27, 0xea,
+	0x18, 0x3f, 0xd0, 0xa2, 0x90, 0xe2, 0x8e, 0xe1, 0xae, 0x4d, 0xbc, 0x84, 0x7e, 0xed, 0x00, 0x7e,
+	0xcf, 0x32, 0x92, 0x2a, 0x89, 0xc1, 0x4a, 0x73, 0x06, 0xfd, 0x2b, 0x96, 0x67, 0x3c, 0xbf, 0x59,
+	0xf3, 0xf1, 0xb8, 0xb9, 0xe1, 0xcf, 0xde, 0x64, 0x56, 0xbb, 0xb6, 0xbe, 0xc9, 0xc1, 0x31, 0xb4,
+	0x46, 0x4c, 0x6a, 0xc3, 0xd1, 0x97, 0xb3, 0xd3, 0xb7, 0x24, 0x65, 0x52, 0x5b, 0x0a, 0x7b, 0x83,
+	0xaf, 0x2e, 0x74, 0x12, 0xa6, 0x0a, 0x91, 0x2b, 0x43, 0xc7, 0x8e, 0xb9, 0xdd, 0x52, 0xd9, 0x46,
+	0xfd, 0xe1, 0xe1, 0x86, 0x8a, 0xba, 0x60, 0x6e, 0x1f, 0xcd, 0x15, 0x33, 0x29, 0x85, 0x24, 0xf5,
+	0x94, 0xe6, 0xf0, 0x8f, 0xa1, 0x65, 0xfb, 0x1a, 0x99, 0xfd, 0xa1, 0xbf, 0x66, 0xc3, 0xcc, 0x8d,
+	0xa0, 0xcb, 0xd7, 0x8e, 0x6a, 0x58, 0x5e, 0xb7, 0x7e, 0xe2, 0xb4, 0x47, 0xae, 0x6a, 0x87, 0xce,
+	0xb7, 0x5c, 0x75, 0x0c, 0xfb, 0x0f, 0xd8, 0x27, 0x85, 0xf9, 0x10, 0xef, 0x18, 0x73, 0x7d, 0x93,
+	0xea, 0xef, 0x61, 0xb7, 0xa8, 0x39, 0xb3, 0x3f, 0x8a, 0xed, 0xe8, 0x27, 0x4c, 0xbe, 0x82, 0x03,
+	0xc9, 0xb4, 0xac, 0x08, 0xbd, 0xd6, 0x4c, 0x6e, 0x2e, 0xd6, 0x08, 0xe2, 0xbd, 0xfe, 0xcb, 0x01,
+	0xff, 0xa1, 0x71, 0xfa, 0x00, 0x8b, 0xf3, 0xd9, 0xe8, 0x9c, 0xcc, 0x27, 0x17, 0xd3, 0xe0, 0x19,
+	0x0a, 0xa0, 0x57, 0xc7, 0x49, 0xfc, 0x71, 0xf6, 0x2e, 0x0e, 0x1c, 0x74, 0x0c, 0x87, 0x75, 0xe6,
+	0x72, 0x76, 0x41, 0x46, 0xb3, 0xe9, 0x7c, 0x32, 0x5f, 0xc4, 0xd3, 0xd1, 0x6f, 0x81, 0xbb, 0xfd,
+	0xf8, 0x6a, 0x76, 0x79, 0x19, 0x78, 0xe8, 0x25, 0xa0, 0xa6, 0x74, 0x32, 0x5f, 0x90, 0xab, 0x78,
+	0x3a, 0x9e, 0x4c, 0x2f, 0x82, 0xd6, 0xb6, 0xe9, 0x38, 0x1e, 0x4d, 0xc6, 0x71, 0xd0, 0x7e, 0xfd,
+	0xd5, 0x81, 0xfe, 0x13, 0x31, 0x7a, 0xd0, 0xa9, 0x8b, 0x66, 0xef, 0x82, 0x67, 0xe8, 0x10, 0xf6,
+	0xeb, 0xe8, 0xa7, 0xf3, 0x31, 0x49, 0xe2, 0xf7, 0x1f, 0xe2, 0xf9, 0x22, 0x70, 0x10, 0x86, 0x17,
+	0xeb, 0xf5, 0x6c, 0x8a, 0x8c, 0xe3, 0xe9, 0x24, 0x1e, 0x07, 0x2e, 0x7a, 0x0e, 0x7e, 0xfd, 0x12,
+	0x27, 0xc9, 0x2c, 0x09, 0x3c, 0x74, 0x04, 0x07, 0xdb, 0x0e, 0x06, 0xdd, 0xf9, 0xe2, 0x43, 0x12,
+	0x07, 0x2d, 0xb4, 0x0f, 0x7b, 0xcd, 0xd6, 0xcd, 0x82, 0xed, 0xed, 0xe2, 0xc9, 0xf9, 0x22, 0x26,
+	0x97, 0x93, 0x5f, 0x26, 0x8b, 0x78, 0x1c, 0xec, 0xfc, 0x37, 0x00, 0xce, 0xa8, 0x78, 0x83, 0x4b,
+	0x06, 0x00, 0x00,
 }
@@ -0,0 +1,356 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/verbose"
+	"github.com/kevinawalsh/taoca/rendezvous"
+)
+
+// Client sends requests to one of several replicas of a certificate authority
+// server, failing over to the others when a replica can't be reached. Replicas
+// that fail are tried again only after a backoff delay, unless all of them are
+// failing. The replicas are either given as a static list, or located using
+// rendezvous lookup, in which case the lookup is repeated periodically and
+// whenever every replica has failed.
+type Client struct {
+	// Servers, if not empty, is a static list of replicas to use instead of
+	// rendezvous lookup.
+	Servers []*Server
+
+	// Name is the name used for rendezvous lookup. If empty,
+	// DefaultServerName is used.
+	Name string
+
+	// MinBackoff and MaxBackoff bound the delay before a failed replica is
+	// tried again. The delay doubles with each consecutive failure. If zero,
+	// 5 seconds and 5 minutes are used.
+	MinBackoff, MaxBackoff time.Duration
+
+	// Refresh is how often rendezvous lookup is repeated. If zero, 10 minutes
+	// is used.
+	Refresh time.Duration
+
+	lock       sync.Mutex
+	resolved   []*Server
+	resolvedAt time.Time
+	health     map[string]*serverHealth
+	pending    map[string]*Server
+}
+
+// serverHealth tracks consecutive failures of a replica.
+type serverHealth struct {
+	failures int
+	retryAt  time.Time
+}
+
+// NewClient creates a client for a static list of replicas.
+func NewClient(servers ...*Server) *Client {
+	return &Client{Servers: servers}
+}
+
+// DefaultClient is the client used to reach the default certificate authority
+// server, unless DefaultServer is set.
+var DefaultClient = &Client{}
+
+// defaultClient returns the client for the default certificate authority
+// server.
+func defaultClient() *Client {
+	if DefaultServer != nil {
+		return NewClient(DefaultServer)
+	}
+	return DefaultClient
+}
+
+func (server *Server) addr() string {
+	return net.JoinHostPort(server.Host, server.Port)
+}
+
+// lookup returns the replicas, repeating rendezvous lookup if needed. Callers
+// must hold c.lock.
+func (c *Client) lookup() ([]*Server, error) {
+	if len(c.Servers) > 0 {
+		return c.Servers, nil
+	}
+	refresh := c.Refresh
+	if refresh <= 0 {
+		refresh = 10 * time.Minute
+	}
+	if c.resolved != nil && time.Since(c.resolvedAt) < refresh {
+		return c.resolved, nil
+	}
+	name := c.Name
+	if name == "" {
+		name = DefaultServerName
+	}
+	b, err := rendezvous.Lookup(name)
+	if err == nil && len(b) == 0 {
+		err = fmt.Errorf("no https certificate authority servers found")
+	}
+	if err != nil {
+		if c.resolved != nil {
+			verbose.Printf("Rendezvous lookup failed, using previous servers: %s\n", err)
+			return c.resolved, nil
+		}
+		return nil, err
+	}
+	c.resolved = nil
+	for _, binding := range b {
+		c.resolved = append(c.resolved, &Server{Host: binding.GetHost(), Port: binding.GetPort()})
+	}
+	c.resolvedAt = time.Now()
+	return c.resolved, nil
+}
+
+// candidates returns the replicas in the order they should be tried: those not
+// backing off first, with the fewest recent failures first, then the rest in
+// the order they become available again.
+func (c *Client) candidates() ([]*Server, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	servers, err := c.lookup()
+	if err != nil {
+		return nil, err
+	}
+	servers = append([]*Server(nil), servers...)
+	now := time.Now()
+	sort.SliceStable(servers, func(i, j int) bool {
+		hi, hj := c.health[servers[i].addr()], c.health[servers[j].addr()]
+		switch {
+		case hi == nil || hj == nil:
+			return hi == nil && hj != nil
+		case now.Before(hi.retryAt) != now.Before(hj.retryAt):
+			return !now.Before(hi.retryAt)
+		case now.Before(hi.retryAt):
+			return hi.retryAt.Before(hj.retryAt)
+		default:
+			return hi.failures < hj.failures
+		}
+	})
+	return servers, nil
+}
+
+// succeeded records that a replica responded.
+func (c *Client) succeeded(server *Server) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.health, server.addr())
+}
+
+// failed records that a replica could not be reached, and backs off from it.
+func (c *Client) failed(server *Server, err error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.health == nil {
+		c.health = make(map[string]*serverHealth)
+	}
+	h := c.health[server.addr()]
+	if h == nil {
+		h = &serverHealth{}
+		c.health[server.addr()] = h
+	}
+	h.failures++
+	min, max := c.MinBackoff, c.MaxBackoff
+	if min <= 0 {
+		min = 5 * time.Second
+	}
+	if max <= 0 {
+		max = 5 * time.Minute
+	}
+	delay := max
+	if h.failures < 32 && min<<uint(h.failures-1) < max {
+		delay = min << uint(h.failures-1)
+	}
+	h.retryAt = time.Now().Add(delay)
+	verbose.Printf("Certificate authority server %s failed, backing off for %v: %s\n", server.addr(), delay, err)
+}
+
+// Do calls f for each replica in turn until one can be reached, i.e. until f
+// returns an error other than UnreachableError. An error from a replica that
+// was reached, e.g. because it denied the request, is returned without trying
+// the others. If no replica can be reached, an UnreachableError is returned.
+// Note that a request that fails after being sent may still have been carried
+// out by the replica.
+func (c *Client) Do(f func(server *Server) error) error {
+	servers, err := c.candidates()
+	if err != nil {
+		return err
+	}
+	var addrs []string
+	for _, server := range servers {
+		err = f(server)
+		if err == context.Canceled || err == context.DeadlineExceeded {
+			return err
+		}
+		if !IsUnreachable(err) {
+			c.succeeded(server)
+			return err
+		}
+		c.failed(server, err)
+		addrs = append(addrs, server.addr())
+		err = err.(*UnreachableError).Err
+	}
+	if len(c.Servers) == 0 {
+		// Look up the replicas again next time.
+		c.lock.Lock()
+		c.resolvedAt = time.Time{}
+		c.lock.Unlock()
+	}
+	return &UnreachableError{strings.Join(addrs, ", "), err}
+}
+
+// Server returns the replica that would be tried first, after checking that it
+// accepts connections. Other replicas are checked in turn if it does not.
+func (c *Client) Server() (*Server, error) {
+	var found *Server
+	err := c.Do(func(server *Server) error {
+		conn, err := net.DialTimeout("tcp", server.addr(), DialTimeout)
+		if err != nil {
+			return &UnreachableError{server.addr(), err}
+		}
+		conn.Close()
+		found = server
+		return nil
+	})
+	return found, err
+}
+
+// Submit sends a CSR to a replica. See Server.Submit.
+func (c *Client) Submit(keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
+	return c.SubmitContext(context.Background(), keys, csr)
+}
+
+// SubmitContext sends a CSR to a replica, giving up when ctx is done. If no
+// replica can be reached, the request is retried as for Server.SubmitContext.
+func (c *Client) SubmitContext(ctx context.Context, keys *tao.Keys, csr *CSR) ([]*x509.Certificate, error) {
+	req, err := signedRequest(keys, csr)
+	if err != nil {
+		return nil, err
+	}
+	return c.sign(ctx, keys, req)
+}
+
+// SubmitPKCS10 sends a PKCS#10 certificate request to a replica. See
+// Server.SubmitPKCS10.
+func (c *Client) SubmitPKCS10(keys *tao.Keys, der []byte) ([]*x509.Certificate, error) {
+	return c.sign(context.Background(), keys, &Request{Pkcs10: der})
+}
+
+// sign sends a signing request to a replica, retrying if none can be reached.
+func (c *Client) sign(ctx context.Context, keys *tao.Keys, req *Request) (certs []*x509.Certificate, err error) {
+	err = retry(ctx, MaxAttempts, func() error {
+		return c.Do(func(server *Server) error {
+			certs, _, err = server.sign(ctx, keys, req)
+			return err
+		})
+	})
+	return
+}
+
+// SubmitAsync sends a CSR to a replica, without waiting for manual approval.
+// The replica that queued the request is remembered for use by Poll. See
+// Server.SubmitAsync.
+func (c *Client) SubmitAsync(keys *tao.Keys, csr *CSR) (certs []*x509.Certificate, id string, err error) {
+	var queued *Server
+	err = c.Do(func(server *Server) error {
+		certs, id, err = server.SubmitAsync(keys, csr)
+		queued = server
+		return err
+	})
+	if err == nil && id != "" {
+		c.lock.Lock()
+		if c.pending == nil {
+			c.pending = make(map[string]*Server)
+		}
+		c.pending[id] = queued
+		c.lock.Unlock()
+	}
+	return
+}
+
+// Poll asks for the outcome of a request queued for manual approval. Requests
+// are queued by a single replica, so the replica that queued the request is
+// used if it is known. See Server.Poll.
+func (c *Client) Poll(keys *tao.Keys, id string, wait bool) ([]*x509.Certificate, error) {
+	c.lock.Lock()
+	server := c.pending[id]
+	c.lock.Unlock()
+	if server != nil {
+		certs, err := server.Poll(keys, id, wait)
+		if err != ErrPending {
+			c.lock.Lock()
+			delete(c.pending, id)
+			c.lock.Unlock()
+		}
+		return certs, err
+	}
+	var certs []*x509.Certificate
+	err := c.Do(func(server *Server) error {
+		var err error
+		certs, err = server.Poll(keys, id, wait)
+		return err
+	})
+	return certs, err
+}
+
+// Revoke asks a replica to revoke a certificate. See Server.Revoke.
+func (c *Client) Revoke(keys *tao.Keys, serial int64, reason int) error {
+	return c.Do(func(server *Server) error {
+		return server.Revoke(keys, serial, reason)
+	})
+}
+
+// Renew obtains and installs a fresh certificate from a replica. See
+// Server.Renew.
+func (c *Client) Renew(keys *tao.Keys) ([]*x509.Certificate, error) {
+	return c.renew(keys, "")
+}
+
+// renew obtains and installs a fresh certificate from a replica, using the
+// given profile, or if that is empty, the profile of the existing certificate.
+func (c *Client) renew(keys *tao.Keys, profile string) ([]*x509.Certificate, error) {
+	old := keys.Cert["default"]
+	if old == nil {
+		return nil, fmt.Errorf("no existing certificate to renew")
+	}
+	certs, err := c.Submit(keys, renewalRequest(keys, old, profile))
+	if err != nil {
+		return nil, err
+	}
+	if err := installCerts(keys, certs); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// Consistency asks a replica for a log consistency proof. See
+// Server.Consistency.
+func (c *Client) Consistency(keys *tao.Keys, first, second int64) (th *TreeHead, proof [][]byte, err error) {
+	err = c.Do(func(server *Server) error {
+		th, proof, err = server.Consistency(keys, first, second)
+		return err
+	})
+	return
+}
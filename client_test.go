@@ -0,0 +1,150 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestClientFailover(t *testing.T) {
+	a := &Server{Host: "10.0.0.1", Port: "8143"}
+	b := &Server{Host: "10.0.0.2", Port: "8143"}
+	c := NewClient(a, b)
+
+	down := map[*Server]bool{a: true}
+	var tried []*Server
+	try := func(server *Server) error {
+		tried = append(tried, server)
+		if down[server] {
+			return &UnreachableError{server.addr(), errors.New("connection refused")}
+		}
+		return nil
+	}
+
+	if err := c.Do(try); err != nil {
+		t.Fatal(err)
+	}
+	if len(tried) != 2 || tried[0] != a || tried[1] != b {
+		t.Fatalf("expected to try a then b, tried %v", tried)
+	}
+
+	// a is backing off, so b is tried first.
+	tried = nil
+	if err := c.Do(try); err != nil {
+		t.Fatal(err)
+	}
+	if len(tried) != 1 || tried[0] != b {
+		t.Fatalf("expected to try only b, tried %v", tried)
+	}
+
+	// A denial from b is returned without failing over.
+	denied := &ResponseError{Status: ResponseStatus_TAOCA_REQUEST_DENIED, Detail: "request is denied"}
+	tried = nil
+	if err := c.Do(func(server *Server) error { tried = append(tried, server); return denied }); err != denied {
+		t.Fatalf("expected denial, got %v", err)
+	}
+	if len(tried) != 1 {
+		t.Fatalf("expected one try, tried %v", tried)
+	}
+
+	// With both down, both are tried, and an error is returned.
+	down[b] = true
+	tried = nil
+	if err := c.Do(try); !IsUnreachable(err) {
+		t.Fatalf("expected unreachable error, got %v", err)
+	}
+	if len(tried) != 2 {
+		t.Fatalf("expected two tries, tried %v", tried)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	defer func(d time.Duration) { RetryBackoff = d }(RetryBackoff)
+	RetryBackoff = time.Millisecond
+
+	n := 0
+	unreachable := &UnreachableError{"10.0.0.1:8143", errors.New("connection refused")}
+	err := retry(context.Background(), 3, func() error { n++; return unreachable })
+	if err != unreachable || n != 3 {
+		t.Fatalf("expected 3 failed attempts, got %d: %v", n, err)
+	}
+
+	n = 0
+	denied := &ResponseError{Status: ResponseStatus_TAOCA_REQUEST_DENIED}
+	err = retry(context.Background(), 3, func() error { n++; return denied })
+	if !IsDenied(err) || n != 1 {
+		t.Fatalf("expected one denied attempt, got %d: %v", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = retry(ctx, 0, func() error { return unreachable })
+	if err != context.Canceled {
+		t.Fatalf("expected cancellation, got %v", err)
+	}
+}
+
+func TestWaitForDecision(t *testing.T) {
+	defer func(d, max time.Duration) { RetryBackoff, MaxRetryBackoff = d, max }(RetryBackoff, MaxRetryBackoff)
+	RetryBackoff, MaxRetryBackoff = time.Millisecond, time.Millisecond
+
+	pending := ResponseStatus_TAOCA_PENDING
+	ok := ResponseStatus_TAOCA_OK
+	unreachable := &UnreachableError{"10.0.0.1:8143", errors.New("i/o timeout")}
+
+	// A server that never answers the poll.
+	n := 0
+	_, err := waitForDecision(context.Background(), func() (*Response, error) {
+		n++
+		return nil, unreachable
+	})
+	if err != unreachable || n != MaxPollAttempts {
+		t.Fatalf("expected %d failed polls, got %d: %v", MaxPollAttempts, n, err)
+	}
+
+	// A server that goes away while the request is pending. Only consecutive
+	// failures count.
+	n = 0
+	_, err = waitForDecision(context.Background(), func() (*Response, error) {
+		n++
+		if n <= 3 || n == 5 {
+			return &Response{Status: &pending}, nil
+		}
+		return nil, unreachable
+	})
+	if err != unreachable || n != 5+MaxPollAttempts {
+		t.Fatalf("expected %d polls, got %d: %v", 5+MaxPollAttempts, n, err)
+	}
+
+	// A decision is returned once made, despite earlier failures.
+	n = 0
+	resp, err := waitForDecision(context.Background(), func() (*Response, error) {
+		n++
+		switch n {
+		case 1:
+			return &Response{Status: &pending}, nil
+		case 2:
+			return nil, unreachable
+		default:
+			return &Response{Status: &ok}, nil
+		}
+	})
+	if err != nil || resp.GetStatus() != ok || n != 3 {
+		t.Fatalf("expected decision after 3 polls, got %d: %v", n, err)
+	}
+}
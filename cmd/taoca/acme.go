@@ -0,0 +1,259 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/profiling"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/acme"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/policy"
+	"github.com/kevinawalsh/taoca/util/x509txt"
+)
+
+// The ACME front-end lets non-Tao tools, such as certbot or Go's autocert,
+// obtain certificates from this CA. Each identifier in an order must be
+// authorized using the "tao-01" challenge: the client responds with a payload
+// of the form {"attestation": "<base64url>"}, holding a serialized
+// tao.Attestation in which some Tao principal P says
+// ACMEKeyAuthorization("<token>.<account key thumbprint>"). When the order is finalized, the guard is consulted as for any other
+// request, with P as the requesting principal, the OU from the ACME CSR, and
+// each identifier in place of the CN. With the -acmehttp01 option, the
+// standard http-01 challenge is also accepted, without consulting the guard.
+// Certificates are issued using the "server" profile, if there is one and the
+// guard allows each attesting principal to use it. Issuance, limits, notices,
+// and denials are otherwise handled just as for other requests, see issue.
+func newACMEServer(baseURL string) *acme.Server {
+	validators := map[string]acme.Validator{"tao-01": validateTaoAttestation}
+	if *options.Bool["acmehttp01"] {
+		validators["http-01"] = acme.HTTP01
+	}
+	return acme.NewServer(baseURL, issueACME, validators)
+}
+
+// acmeLifetime is the validity period for certificates issued using ACME, unless
+// the profile or policy sets a shorter one.
+const acmeLifetime = 90 * 24 * time.Hour
+
+// acmeProfile is the certificate profile used for ACME orders.
+const acmeProfile = "server"
+
+// acmeDenial records a denied ACME order, and returns the error to send to the
+// client.
+func acmeDenial(rec *issuance.Record, status taoca.ResponseStatus, detail string) error {
+	resp := denialResponse(rec, nil, status, detail)
+	return fmt.Errorf("%s", resp.GetErrorDetail())
+}
+
+// validateTaoAttestation checks a tao-01 challenge response, returning the
+// attesting principal as evidence.
+func validateTaoAttestation(authz *acme.Authorization, keyAuth string, payload []byte) (interface{}, error) {
+	var resp struct {
+		Attestation string `json:"attestation"`
+	}
+	if err := json.Unmarshal(payload, &resp); err != nil {
+		return nil, err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(resp.Attestation)
+	if err != nil {
+		return nil, err
+	}
+	var a tao.Attestation
+	if err := proto.Unmarshal(data, &a); err != nil {
+		return nil, err
+	}
+	says, err := a.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if !says.Active(time.Now().UnixNano()) {
+		return nil, fmt.Errorf("attestation is not currently valid")
+	}
+	stmt, ok := says.Message.(auth.Pred)
+	if !ok || stmt.Name != "ACMEKeyAuthorization" || len(stmt.Arg) != 1 || !stmt.Arg[0].Identical(auth.Str(keyAuth)) {
+		return nil, fmt.Errorf("attestation does not contain the key authorization")
+	}
+	prin, ok := says.Speaker.(auth.Prin)
+	if !ok {
+		return nil, fmt.Errorf("attestation speaker is not a principal")
+	}
+	return prin, nil
+}
+
+// issueACME signs a certificate for a finalized ACME order.
+func issueACME(order *acme.Order, csr *x509.CertificateRequest) ([][]byte, error) {
+	requested := time.Now()
+	var errmsg string
+	var ou string
+	if len(csr.Subject.OrganizationalUnit) > 0 {
+		ou = sanitize(&csr.Subject.OrganizationalUnit[0], "OrganizationalUnit", &errmsg)
+	}
+	details := &taoca.X509Details{}
+	for _, id := range order.Identifiers {
+		if id.Type == "ip" {
+			details.IpAddress = append(details.IpAddress, id.Value)
+		} else {
+			details.DnsName = append(details.DnsName, id.Value)
+		}
+	}
+	cn := order.Identifiers[0].Value
+	name := &pkix.Name{CommonName: cn}
+	if ou != "" {
+		name.OrganizationalUnit = []string{ou}
+	}
+	rec := &issuance.Record{
+		Subject:            x509txt.RDNString(*name),
+		OrganizationalUnit: ou,
+		CommonName:         cn,
+		AltNames:           requestedAltNames(details),
+		Peer:               "acme account " + order.Account.Thumbprint,
+	}
+	alt := sanitizeAltNames(details, &errmsg)
+	lifetime := acmeLifetime
+	prof := profiles[acmeProfile]
+	if prof != nil && errmsg == "" {
+		rec.Profile = prof.Name
+		if errmsg = checkProfile(prof, false, alt); errmsg == "" {
+			lifetime, errmsg = clampLifetime(lifetime, prof.MaxLifetime, "profile")
+		}
+	}
+	if errmsg != "" {
+		return nil, acmeDenial(rec, taoca.ResponseStatus_TAOCA_BAD_REQUEST, errmsg)
+	}
+
+	// Consult guard to enforce policy for each attested identifier.
+	var peers []string
+	var prins []auth.Prin
+	var attested []*acme.Authorization
+	for _, a := range order.Authorizations {
+		prin, ok := a.Evidence.(auth.Prin)
+		if !ok {
+			continue // http-01, for testing only.
+		}
+		attested = append(attested, a)
+		if !containsPrin(prins, prin) {
+			peers = append(peers, prin.String())
+			prins = append(prins, prin)
+		}
+	}
+	if len(peers) > 0 {
+		rec.Peer = strings.Join(peers, ", ")
+	}
+	var explanations []*policy.Explanation
+	var v policyVersion
+	for i, a := range attested {
+		prin, id := a.Evidence.(auth.Prin), a.Identifier.Value
+		e, use, pv := explainRequest(prin, ou, id, nil, prof)
+		if !e.Allowed {
+			resp := policyDenialResponse(rec, e, "request is denied")
+			return nil, fmt.Errorf("%s", resp.GetErrorDetail())
+		}
+		if use != nil && !use.Allowed {
+			resp := policyDenialResponse(rec, use, "certificate profile is not allowed")
+			return nil, fmt.Errorf("%s", resp.GetErrorDetail())
+		}
+		if i > 0 && pv.hash != v.hash {
+			return nil, fmt.Errorf("certificate-granting policy changed while checking order, try again")
+		}
+		v = pv
+		explanations = append(explanations, e)
+		if use != nil {
+			explanations = append(explanations, use)
+		}
+		lifetime, errmsg = clampLifetime(lifetime, maxLifetime(prin, ou, id), "policy")
+		if errmsg != "" {
+			return nil, acmeDenial(rec, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, errmsg)
+		}
+	}
+	if len(attested) == 0 {
+		v = currentPolicyVersion()
+	}
+
+	if err := checkKey(csr.PublicKey); err != nil {
+		return nil, acmeDenial(rec, taoca.ResponseStatus_TAOCA_BAD_REQUEST, err.Error())
+	}
+	subjectKey, err := tao.FromX509(&x509.Certificate{PublicKey: csr.PublicKey})
+	if err != nil {
+		return nil, acmeDenial(rec, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "unsupported public key: "+err.Error())
+	}
+	rec.SubjectKey = subjectKey.ToPrincipal().String()
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	rec.Serial = serial
+
+	c := &csrInfo{
+		name:        name,
+		rec:         rec,
+		alt:         alt,
+		subjectKey:  subjectKey,
+		serial:      serial,
+		lifetime:    lifetime,
+		profile:     prof,
+		ou:          ou,
+		cn:          cn,
+		requested:   requested,
+		policyHash:  v.hash,
+		limitPrins:  prins,
+		acmeAccount: order.Account.Thumbprint,
+	}
+	if len(explanations) > 0 {
+		c.explanation = policy.Merge(explanations...)
+	}
+	// When a single Tao principal attested every identifier, the notice names
+	// its manifest, as for a request made by that principal directly.
+	if len(prins) == 1 && len(attested) == len(order.Authorizations) {
+		c.peer = &prins[0]
+	}
+
+	T := profiling.NewTrace(10, 1)
+	T.Start()
+	resp := issue(c, v.cps, T)
+	switch resp.GetStatus() {
+	case taoca.ResponseStatus_TAOCA_OK:
+	case taoca.ResponseStatus_TAOCA_RATE_LIMITED:
+		wait := time.Duration(resp.GetRetryAfterSeconds()) * time.Second
+		return nil, &acme.RateLimitError{Detail: resp.GetErrorDetail(), RetryAfter: wait}
+	default:
+		return nil, fmt.Errorf("%s", resp.GetErrorDetail())
+	}
+	var chain [][]byte
+	for _, cert := range resp.Cert {
+		chain = append(chain, cert.X509Cert)
+	}
+	return chain, nil
+}
+
+func containsPrin(prins []auth.Prin, p auth.Prin) bool {
+	for _, q := range prins {
+		if q.Identical(p) {
+			return true
+		}
+	}
+	return false
+}
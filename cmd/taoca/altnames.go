@@ -0,0 +1,164 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// altNames holds the sanitized subject alternative names from a CSR.
+type altNames struct {
+	dns  []string
+	ips  []net.IP
+	uris []*url.URL
+}
+
+// claims returns each alternative name in the form passed to the guard, i.e.
+// a bare hostname, ip address, or uri, just as CommonName is passed.
+func (a *altNames) claims() []string {
+	var s []string
+	s = append(s, a.dns...)
+	for _, ip := range a.ips {
+		s = append(s, ip.String())
+	}
+	for _, u := range a.uris {
+		s = append(s, u.String())
+	}
+	return s
+}
+
+// requestedAltNames lists the unsanitized alternative names from a CSR, each
+// prefixed by its type, for display and record keeping.
+func requestedAltNames(name *taoca.X509Details) []string {
+	var s []string
+	for _, n := range name.DnsName {
+		s = append(s, "DNS:"+n)
+	}
+	for _, n := range name.IpAddress {
+		s = append(s, "IP:"+n)
+	}
+	for _, n := range name.Uri {
+		s = append(s, "URI:"+n)
+	}
+	return s
+}
+
+var dnsChars = "abcdefghijklmnopqrstuvwxyz1234567890-"
+
+// sanitizeAltNames checks the alternative names in a CSR. DNS names are
+// lowercased, and wildcards are not accepted.
+func sanitizeAltNames(name *taoca.X509Details, errmsg *string) *altNames {
+	a := &altNames{}
+	if *errmsg != "" {
+		return a
+	}
+	for _, n := range name.DnsName {
+		n = strings.ToLower(n)
+		if !validDNSName(n) {
+			*errmsg = "invalid name.DnsName"
+			return a
+		}
+		a.dns = append(a.dns, n)
+	}
+	for _, n := range name.IpAddress {
+		ip := net.ParseIP(n)
+		if ip == nil {
+			*errmsg = "invalid name.IpAddress"
+			return a
+		}
+		a.ips = append(a.ips, ip)
+	}
+	for _, n := range name.Uri {
+		u, err := url.Parse(n)
+		if err != nil || !validURI(n, u) {
+			*errmsg = "invalid name.Uri"
+			return a
+		}
+		a.uris = append(a.uris, u)
+	}
+	return a
+}
+
+func validDNSName(n string) bool {
+	if len(n) == 0 || len(n) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(n, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if !strings.ContainsRune(dnsChars, rune(label[i])) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func validURI(n string, u *url.URL) bool {
+	if len(n) > 1024 || u.Scheme == "" || u.User != nil {
+		return false
+	}
+	if u.Host == "" && u.Opaque == "" {
+		return false
+	}
+	for i := 0; i < len(n); i++ {
+		if n[i] <= ' ' || n[i] >= 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// explainClaims checks whether guard g allows prin to claim a certificate
+// with the given OU, CN, and alternative names, and explains the decision. Each
+// alternative name is checked in place of the CN, so the same rules govern
+// both.
+func explainClaims(g tao.Guard, prin auth.Prin, ou, cn string, alt []string) *policy.Explanation {
+	if e := policy.Explain(g, prin, "ClaimCertificate", nil); e.Allowed {
+		return e
+	}
+	var explanations []*policy.Explanation
+	for _, claim := range append([]string{cn}, alt...) {
+		explanations = append(explanations, policy.Explain(g, prin, "ClaimCertificate", []string{ou, claim}))
+	}
+	return policy.Merge(explanations...)
+}
+
+// explainRequest checks whether the guard allows prin to claim a certificate
+// with the given OU, CN, and alternative names, see explainClaims, and if prof
+// is not nil, whether it allows prin to use that profile, see explainProfile.
+// Both decisions are made by the same policy, which is returned along with the
+// explanations. The profile is not checked if the claims are denied.
+func explainRequest(prin auth.Prin, ou, cn string, alt []string, prof *policy.Profile) (claims, use *policy.Explanation, v policyVersion) {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	claims = explainClaims(guard, prin, ou, cn, alt)
+	if claims.Allowed && prof != nil {
+		use = explainProfile(guard, prin, prof.Name)
+	}
+	return claims, use, lockedPolicyVersion()
+}
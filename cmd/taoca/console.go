@@ -0,0 +1,324 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// The admin console is a web interface to the CA, served over HTTPS using the
+// CA's own certificate. Clients must present a certificate, either one for a
+// key whose principal is listed in the "admins" file, or one that chains to a
+// certificate in the file given by the -adminroots option. The console shows
+// pending requests, issued and denied certificates, and the active policy, and
+// it lets an admin approve or deny pending requests and revoke certificates.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca/https"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/util/x509txt"
+)
+
+// adminRoots holds the certificates trusted to certify admin client
+// certificates, if any.
+var adminRoots *x509.CertPool
+
+// consoleToken is embedded in every console form, and checked on every post,
+// to guard against cross-site request forgery.
+var consoleToken string
+
+// serveConsole serves the admin console at addr. It does not return.
+func serveConsole(addr, roots string) {
+	if roots != "" {
+		pem, err := ioutil.ReadFile(roots)
+		options.FailIf(err, "Can't read admin root certificates")
+		adminRoots = x509.NewCertPool()
+		if !adminRoots.AppendCertsFromPEM(pem) {
+			options.Fail(nil, "No certificates found in %s", roots)
+		}
+	}
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	options.FailIf(err, "Can't generate console token")
+	consoleToken = hex.EncodeToString(b)
+
+	tlsCert, err := tao.EncodeTLSCert(caKeys)
+	options.FailIf(err, "Can't encode CA certificate for admin console")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", consoleIndex)
+	mux.HandleFunc("/record/", consoleRecord)
+	mux.HandleFunc("/decide", consoleDecide)
+	mux.HandleFunc("/revoke", consoleRevoke)
+	mux.Handle("/cert/", https.CertificateHandler{caKeys.CertificatePool})
+
+	srv := &http.Server{
+		Addr:    addr,
+		Handler: consoleAuth(mux),
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{*tlsCert},
+			ClientAuth:   tls.RequireAnyClientCert,
+		},
+	}
+	err = srv.ListenAndServeTLS("", "")
+	options.FailIf(err, "can't serve admin console")
+}
+
+// consoleAdmin returns the name of the admin that presented the client
+// certificate for a console request, if any.
+func consoleAdmin(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	leaf := r.TLS.PeerCertificates[0]
+	if v, err := tao.FromX509(leaf); err == nil {
+		if p := v.ToPrincipal(); isAdmin(p) {
+			return p.String(), true
+		}
+	}
+	if adminRoots != nil {
+		intermediates := x509.NewCertPool()
+		for _, cert := range r.TLS.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+		_, err := leaf.Verify(x509.VerifyOptions{
+			Roots:         adminRoots,
+			Intermediates: intermediates,
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		})
+		if err == nil {
+			return "x509:" + x509txt.RDNString(leaf.Subject), true
+		}
+	}
+	return "", false
+}
+
+// consoleAuth wraps a handler so it only serves requests from admins, and only
+// accepts posts that carry the console token.
+func consoleAuth(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		admin, ok := consoleAdmin(r)
+		if !ok {
+			fmt.Printf("admin console: denied access to %s\n", r.RemoteAddr)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if r.Method == "POST" {
+			token := r.PostFormValue("token")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(consoleToken)) != 1 {
+				http.Error(w, "Bad form token", http.StatusForbidden)
+				return
+			}
+		}
+		fmt.Printf("admin console: %s %s by %s\n", r.Method, r.URL.Path, admin)
+		h.ServeHTTP(w, r)
+	})
+}
+
+type consolePending struct {
+	ID, Details string
+}
+
+func consoleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	var data struct {
+		Token   string
+		Manual  bool
+		Pending []consolePending
+		Issued  []*issuance.Record
+		Denied  []*issuance.Record
+		Policy  string
+	}
+	data.Token = consoleToken
+	data.Manual = manualMode
+	for _, p := range undecided() {
+		data.Pending = append(data.Pending, consolePending{p.id, p.details})
+	}
+	data.Issued = issued.Find(&issuance.Query{IssuedOnly: true})
+	data.Denied = issued.Find(&issuance.Query{DeniedOnly: true})
+	if g := currentGuard(); g != nil {
+		data.Policy = g.String()
+	}
+	var buf bytes.Buffer
+	if err := consoleTemplate.Execute(&buf, data); err != nil {
+		fmt.Printf("admin console: %s\n", err)
+		http.Error(w, "can't render console", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if _, err := buf.WriteTo(w); err != nil {
+		fmt.Printf("admin console: %s\n", err)
+	}
+}
+
+func consoleRecord(w http.ResponseWriter, r *http.Request) {
+	serial, err := strconv.ParseInt(r.URL.Path[len("/record/"):], 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	rec := issued.Lookup(serial)
+	if rec == nil {
+		http.NotFound(w, r)
+		return
+	}
+	cert, err := rec.Certificate()
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s := x509txt.Html(cert)
+	for _, parent := range caKeys.CertChain("default") {
+		s += x509txt.Html(parent)
+	}
+	// Render fully before writing, so errors can still be reported.
+	var buf bytes.Buffer
+	if err := certTemplate.Execute(&buf, template.HTML(s)); err != nil {
+		fmt.Printf("admin console: %s\n", err)
+		http.Error(w, "can't render certificate", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	if _, err := buf.WriteTo(w); err != nil {
+		fmt.Printf("admin console: %s\n", err)
+	}
+}
+
+var certTemplate = template.Must(template.New("show").Parse(https.CertTemplate))
+
+func consoleDecide(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, _ := consoleAdmin(r)
+	approve := r.PostFormValue("decision") == "approve"
+	if _, err := decide(r.PostFormValue("id"), approve, admin); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+func consoleRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	admin, _ := consoleAdmin(r)
+	serial, err := strconv.ParseInt(r.PostFormValue("serial"), 10, 64)
+	if err != nil {
+		http.Error(w, "bad serial number", http.StatusBadRequest)
+		return
+	}
+	reason, err := strconv.Atoi(r.PostFormValue("reason"))
+	if err != nil {
+		reason = 0
+	}
+	rec := issued.Lookup(serial)
+	if rec == nil || !rec.Issued() {
+		http.Error(w, "no such certificate", http.StatusBadRequest)
+		return
+	}
+	if err := revoke(rec, reason, admin); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+var consoleTemplate = template.Must(template.New("console").Parse(`
+<!DOCTYPE html>
+<html><head>
+<meta charset="UTF-8">
+<title>TaoCA Admin Console</title>
+</head>
+<body>
+<h1>TaoCA Admin Console</h1>
+<p><a href="/cert/">CA Certificates</a></p>
+
+{{if .Manual}}
+<h2>Pending Requests</h2>
+{{range .Pending}}
+  <h3>Request {{.ID}}</h3>
+  <pre>{{.Details}}</pre>
+  <form action="/decide" method="post">
+  <input type="hidden" name="token" value="{{$.Token}}">
+  <input type="hidden" name="id" value="{{.ID}}">
+  <button type="submit" name="decision" value="approve">Approve</button>
+  <button type="submit" name="decision" value="deny">Deny</button>
+  </form>
+{{else}}
+  <p>None</p>
+{{end}}
+{{end}}
+
+<h2>Issued Certificates</h2>
+<table border="1">
+<tr><th>Serial</th><th>Time</th><th>Subject</th><th>Requested By</th><th>Expires</th><th>Status</th></tr>
+{{range .Issued}}
+  <tr>
+  <td><a href="/record/{{.Serial}}">{{.Serial}}</a></td>
+  <td>{{.Time}}</td>
+  <td>{{.Subject}}</td>
+  <td>{{.Peer}}</td>
+  <td>{{.NotAfter}}</td>
+  <td>{{if .IsRevoked}}Revoked {{.Revoked}}{{else}}
+    <form action="/revoke" method="post">
+    <input type="hidden" name="token" value="{{$.Token}}">
+    <input type="hidden" name="serial" value="{{.Serial}}">
+    Reason <input type="text" name="reason" value="0" size="2">
+    <button type="submit">Revoke</button>
+    </form>{{end}}</td>
+  </tr>
+{{else}}
+  <tr><td colspan="6">None</td></tr>
+{{end}}
+</table>
+
+<h2>Denied Requests</h2>
+<table border="1">
+<tr><th>Time</th><th>Subject</th><th>Requested By</th><th>Status</th><th>Detail</th></tr>
+{{range .Denied}}
+  <tr>
+  <td>{{.Time}}</td>
+  <td>{{.Subject}}</td>
+  <td>{{.Peer}}</td>
+  <td>{{.Status}}</td>
+  <td>{{.Detail}}</td>
+  </tr>
+{{else}}
+  <tr><td colspan="5">None</td></tr>
+{{end}}
+</table>
+
+{{if .Policy}}
+<h2>Certificate-Granting Policy</h2>
+<pre>{{.Policy}}</pre>
+{{end}}
+</body></html>`))
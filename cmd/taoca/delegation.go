@@ -0,0 +1,58 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+// checkDelegation verifies that a serialized attestation binds a subject key to
+// the requesting principal, i.e. it is a currently valid statement, made by the
+// peer or one of its ancestors, that the key speaks for the peer.
+func checkDelegation(data []byte, key *tao.Verifier, peer *auth.Prin) error {
+	if peer == nil {
+		return fmt.Errorf("delegation from anonymous principal")
+	}
+	var a tao.Attestation
+	if err := proto.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	says, err := a.Validate()
+	if err != nil {
+		return err
+	}
+	if !says.Active(time.Now().UnixNano()) {
+		return fmt.Errorf("delegation is not currently valid")
+	}
+	sf, ok := says.Message.(auth.Speaksfor)
+	if !ok {
+		return fmt.Errorf("attestation is not a delegation: %v", says)
+	}
+	if !sf.Delegate.Identical(key.ToPrincipal()) {
+		return fmt.Errorf("delegation is for a different key: %v", sf.Delegate)
+	}
+	if !sf.Delegator.Identical(*peer) {
+		return fmt.Errorf("delegation is to a different principal: %v", sf.Delegator)
+	}
+	if !auth.SubprinOrIdentical(*peer, says.Speaker) {
+		return fmt.Errorf("delegation is made by an unrelated principal: %v", says.Speaker)
+	}
+	return nil
+}
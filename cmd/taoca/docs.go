@@ -0,0 +1,155 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+)
+
+// Published CPS and unotice documents are content-addressed: each is stored in
+// the -docdir directory as <sha256>.txt, or <sha256>.json for machine-readable
+// notices. With the -docaddr option, the CA
+// serves these documents itself over HTTPS, at the path given by -docurl. A
+// document is only served if its hash matches its name.
+
+var docName = regexp.MustCompile(`^[0-9a-f]{64}\.(txt|json)$`)
+
+// docServer serves the documents in a directory.
+type docServer struct {
+	dir string
+}
+
+// serveDocs serves published documents at addr. It does not return.
+func serveDocs(addr string) {
+	u, err := url.Parse(*options.String["docurl"])
+	options.FailIf(err, "bad -docurl option")
+	prefix := u.Path
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	tlsCert, err := tao.EncodeTLSCert(caKeys)
+	options.FailIf(err, "Can't encode CA certificate for document server")
+
+	mux := http.NewServeMux()
+	mux.Handle(prefix, http.StripPrefix(prefix, docServer{*options.String["docdir"]}))
+	srv := &http.Server{
+		Addr:      addr,
+		Handler:   mux,
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{*tlsCert}},
+	}
+	err = srv.ListenAndServeTLS("", "")
+	options.FailIf(err, "can't serve documents")
+}
+
+// readDoc reads a published document, checking that its hash matches its name.
+func (ds docServer) readDoc(name string) ([]byte, error) {
+	if !docName.MatchString(name) {
+		return nil, fmt.Errorf("not a document name: %q", name)
+	}
+	doc, err := ioutil.ReadFile(path.Join(ds.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprintf("%x%s", sha256.Sum256(doc), path.Ext(name)) != name {
+		return nil, fmt.Errorf("hash of %s does not match its name", name)
+	}
+	return doc, nil
+}
+
+func (ds docServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Path
+	if name == "" || name == "index.html" {
+		ds.serveIndex(w, req)
+		return
+	}
+	doc, err := ds.readDoc(name)
+	if err != nil {
+		fmt.Printf("document server: %s\n", err)
+		http.NotFound(w, req)
+		return
+	}
+	if path.Ext(name) == ".json" {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(doc)
+}
+
+type docInfo struct {
+	Name  string
+	Time  time.Time
+	Size  int64
+	Valid bool
+}
+
+func (ds docServer) serveIndex(w http.ResponseWriter, req *http.Request) {
+	files, err := ioutil.ReadDir(ds.dir)
+	if err != nil {
+		http.Error(w, "documents not available", http.StatusServiceUnavailable)
+		return
+	}
+	var docs []docInfo
+	for _, fi := range files {
+		if !docName.MatchString(fi.Name()) {
+			continue
+		}
+		_, err := ds.readDoc(fi.Name())
+		docs = append(docs, docInfo{fi.Name(), fi.ModTime(), fi.Size(), err == nil})
+	}
+	sort.Slice(docs, func(i, j int) bool { return docs[i].Time.Before(docs[j].Time) })
+	w.Header().Set("Content-Type", "text/html")
+	if err := docIndexTemplate.Execute(w, docs); err != nil {
+		fmt.Printf("document server: %s\n", err)
+	}
+}
+
+var docIndexTemplate = template.Must(template.New("docs").Parse(`
+<!DOCTYPE html>
+<html><head>
+<meta charset="UTF-8">
+<title>TaoCA Published Documents</title>
+</head>
+<body>
+<h2>Published Documents</h2>
+<p>Each document is named by the SHA-256 hash of its contents.</p>
+<table border="1">
+<tr><th>Document</th><th>Published</th><th>Size</th></tr>
+{{range .}}
+  <tr>
+  <td>{{if .Valid}}<a href="{{.Name}}">{{.Name}}</a>{{else}}{{.Name}} (hash mismatch){{end}}</td>
+  <td>{{.Time}}</td>
+  <td>{{.Size}}</td>
+  </tr>
+{{else}}
+  <tr><td colspan="3">None</td></tr>
+{{end}}
+</table>
+</body></html>`))
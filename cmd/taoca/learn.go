@@ -0,0 +1,93 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/kevinawalsh/taoca/netlog"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// With the -learn option, the CA trusts the program hash of each requesting
+// principal it has not seen before. Each learned rule is written to the
+// "learned" file in the keys directory, pending review using the taoca_learned
+// command, which can promote rules into the policy file or discard them. The
+// learned rules are reloaded along with the policy, so they survive restarts.
+
+// learnedPath is the file holding rules learned in learn mode.
+var learnedPath string
+
+// learn trusts the program hash of prin, if it is not already trusted. The rule
+// is saved to the learned file before it is trusted, so that it is not lost on
+// the next reload. If it can't be saved, it is not trusted and an error is
+// returned. Callers must hold guardLock for writing.
+func learn(prin auth.Prin, ou, cn string) error {
+	if len(prin.Ext) == 0 {
+		return nil
+	}
+	last := prin.Ext[len(prin.Ext)-1]
+	tail := auth.PrinTail{
+		Ext: auth.SubPrin([]auth.PrinExt{last}),
+	}
+	prinHash := fmt.Sprintf("Known(%v)", tail)
+	if knownHashes[prinHash] || hasRule(guard, prinHash) {
+		return nil
+	}
+	l := &policy.Learned{
+		Rule:      prinHash,
+		Principal: prin.String(),
+		Time:      time.Now(),
+		OU:        ou,
+		CN:        cn,
+	}
+	if err := policy.AppendLearned(learnedPath, l); err != nil {
+		return fmt.Errorf("can't save learned rule %s: %s", prinHash, err)
+	}
+	if err := guard.AddRule(prinHash); err != nil {
+		return fmt.Errorf("can't add learned rule %s: %s", prinHash, err)
+	}
+	knownHashes[prinHash] = true
+	fmt.Printf("Learned: %s\n", prinHash)
+	netlog.Log("https_ca: learned %s", prinHash)
+	return nil
+}
+
+// loadLearned returns the learned rules in the learned file, so that promoted
+// and discarded rules can be dropped.
+func loadLearned() (map[string]bool, error) {
+	learned, err := policy.LoadLearned(learnedPath)
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool)
+	for _, l := range learned {
+		known[l.Rule] = true
+	}
+	return known, nil
+}
+
+// hasRule checks whether a guard already holds a rule.
+func hasRule(g tao.Guard, rule string) bool {
+	for i := 0; i < g.RuleCount(); i++ {
+		if g.GetRule(i) == rule {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,213 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// Rate limits and quotas are enforced by counting the certificates recorded in
+// the issuance database, so the limiter state survives restarts. The counts are
+// kept up to date as records are appended, so checking limits needn't scan the
+// database. Certificates that no longer count against any limit are pruned
+// from the counts from time to time, and when the policy is reloaded, the
+// counts are rebuilt from the database, in case the new limits count
+// certificates the old ones did not.
+
+// limitLock serializes checking limits and recording certificates, so
+// concurrent requests can't all slip in under a limit. It is held from the
+// check until the issuance record is appended, but not while logging the
+// certificate or sending the response.
+var limitLock = &sync.Mutex{}
+
+// usageLock protects usage, usageBySerial, usageCounted, and usagePruned.
+var usageLock = &sync.Mutex{}
+
+// usage holds the certificates counted against limits, for each key of each
+// kind, i.e. for each requesting principal, program hash, and CN.
+var usage = map[string]map[string][]*policy.Usage{
+	"principal": make(map[string][]*policy.Usage),
+	"program":   make(map[string][]*policy.Usage),
+	"cn":        make(map[string][]*policy.Usage),
+}
+
+// usageBySerial holds the counted certificates by serial, for revocations.
+var usageBySerial = make(map[int64]*policy.Usage)
+
+// usageCounted is the number of issuance records passed to countUsage.
+var usageCounted int
+
+// usagePruned is when the counts were last pruned.
+var usagePruned time.Time
+
+// usagePrunePeriod is how often the counts are pruned.
+const usagePrunePeriod = time.Minute
+
+// countUsage updates the counts for a record appended to the issuance
+// database.
+func countUsage(r *issuance.Record) {
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	usageCounted++
+	addUsage(r)
+}
+
+// addUsage counts an issuance record. Callers must hold usageLock.
+func addUsage(r *issuance.Record) {
+	if r.Revocation() {
+		if u, ok := usageBySerial[r.Serial]; ok && r.Time.Before(u.Expires) {
+			u.Expires = r.Time
+		}
+		return
+	}
+	if !r.Issued() {
+		return
+	}
+	u := &policy.Usage{Issued: r.Time, Expires: r.NotAfter}
+	if r.IsRevoked() && r.Revoked.Before(u.Expires) {
+		u.Expires = r.Revoked
+	}
+	usageBySerial[r.Serial] = u
+	usage["principal"][r.Peer] = append(usage["principal"][r.Peer], u)
+	if prog := recordProgram(r); prog != "" {
+		usage["program"][prog] = append(usage["program"][prog], u)
+	}
+	usage["cn"][r.CommonName] = append(usage["cn"][r.CommonName], u)
+}
+
+// pruneUsage discards the certificates that no longer count against any of the
+// given limits. Callers must hold usageLock.
+func pruneUsage(ls []*policy.Limit, now time.Time) {
+	counts := func(u *policy.Usage) bool {
+		for _, l := range ls {
+			if l.Counts(u, now) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, m := range usage {
+		for key, used := range m {
+			var live []*policy.Usage
+			for _, u := range used {
+				if counts(u) {
+					live = append(live, u)
+				}
+			}
+			if len(live) == 0 {
+				delete(m, key)
+			} else {
+				m[key] = live
+			}
+		}
+	}
+	for serial, u := range usageBySerial {
+		if !counts(u) {
+			delete(usageBySerial, serial)
+		}
+	}
+	usagePruned = now
+}
+
+// rebuildUsage recounts every certificate in the issuance database. It must
+// not be called before countUsage is registered with the database.
+func rebuildUsage() {
+	for {
+		records := issued.Records()
+		usageLock.Lock()
+		// Records appended since the snapshot have been passed to countUsage,
+		// but would be lost here, so try again.
+		if len(records) != usageCounted {
+			usageLock.Unlock()
+			continue
+		}
+		for _, m := range usage {
+			for key := range m {
+				delete(m, key)
+			}
+		}
+		usageBySerial = make(map[int64]*policy.Usage)
+		for _, r := range records {
+			addUsage(r)
+		}
+		usagePruned = time.Time{}
+		usageLock.Unlock()
+		return
+	}
+}
+
+// programOf returns the program hash subprincipal of a principal, i.e. the last
+// extension, or "" if there is none.
+func programOf(prin auth.Prin) string {
+	if len(prin.Ext) == 0 {
+		return ""
+	}
+	last := prin.Ext[len(prin.Ext)-1]
+	tail := auth.PrinTail{
+		Ext: auth.SubPrin([]auth.PrinExt{last}),
+	}
+	return tail.String()
+}
+
+// recordProgram returns the program hash subprincipal of the peer that made a
+// request, or "" if there is none.
+func recordProgram(rec *issuance.Record) string {
+	var p auth.Prin
+	if _, err := fmt.Sscanf(rec.Peer, "%v", &p); err != nil {
+		return ""
+	}
+	return programOf(p)
+}
+
+// currentLimits returns the policy limits now in effect.
+func currentLimits() []*policy.Limit {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	return limits
+}
+
+// checkLimits checks whether issuing another certificate for cn, requested by
+// prin, would exceed any policy limit. If so, it returns the limit and how
+// long until the request might succeed. Callers must hold limitLock.
+func checkLimits(prin auth.Prin, cn string) (*policy.Limit, time.Duration) {
+	now := time.Now()
+	keys := map[string]string{
+		"principal": prin.String(),
+		"program":   programOf(prin),
+		"cn":        cn,
+	}
+	usageLock.Lock()
+	defer usageLock.Unlock()
+	ls := currentLimits()
+	if now.Sub(usagePruned) >= usagePrunePeriod {
+		pruneUsage(ls, now)
+	}
+	for _, l := range ls {
+		key := keys[l.Key]
+		if key == "" {
+			continue
+		}
+		if exceeded, wait := l.Exceeded(usage[l.Key][key], now); exceeded {
+			return l, wait
+		}
+	}
+	return nil, 0
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"math/big"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/ocsp"
+	"github.com/kevinawalsh/taoca/util/x509txt"
+)
+
+// lookupStatus finds the revocation status of a certificate in the issuance
+// database.
+func lookupStatus(serial *big.Int) ocsp.CertStatus {
+	if serial.Sign() <= 0 || serial.BitLen() > 63 {
+		return ocsp.CertStatus{Status: ocsp.Unknown}
+	}
+	rec := issued.Lookup(serial.Int64())
+	switch {
+	case rec == nil:
+		return ocsp.CertStatus{Status: ocsp.Unknown}
+	case rec.IsRevoked():
+		return ocsp.CertStatus{Status: ocsp.Revoked, RevocationTime: rec.Revoked, Reason: rec.Reason}
+	default:
+		return ocsp.CertStatus{Status: ocsp.Good}
+	}
+}
+
+// newOCSPResponder creates a fresh OCSP signing key and has the CA certify it.
+// The signing key is not stored anywhere, so a new one is made on each restart.
+func newOCSPResponder() (*ocsp.Responder, error) {
+	period, err := time.ParseDuration(*options.String["ocspperiod"])
+	if err != nil {
+		return nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	// CreateSignedX509 needs a tao.Verifier for the subject key, and the only
+	// way to get one for a non-tao key is by way of a certificate.
+	self := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, self, self, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	self, err = x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	subjectKey, err := tao.FromX509(self)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+	issuer := caKeys.Cert["default"]
+	name := issuer.Subject
+	name.CommonName += " OCSP Responder"
+	template := caKeys.SigningKey.X509Template(&name, ocsp.NoCheckExtension())
+	template.IsCA = false
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+	template.SerialNumber.SetInt64(serial)
+	cert, err := caKeys.CreateSignedX509(subjectKey, template, "default")
+	if err != nil {
+		return nil, err
+	}
+
+	err = issued.Append(&issuance.Record{
+		Serial:             serial,
+		Time:               time.Now(),
+		Status:             taoca.ResponseStatus_TAOCA_OK.String(),
+		Subject:            x509txt.RDNString(cert.Subject),
+		OrganizationalUnit: firstOf(cert.Subject.OrganizationalUnit),
+		CommonName:         cert.Subject.CommonName,
+		Peer:               "self (OCSP responder)",
+		SubjectKey:         subjectKey.ToPrincipal().String(),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		Cert:               cert.Raw,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := logCertificate(cert.Raw); err != nil {
+		return nil, err
+	}
+
+	return &ocsp.Responder{
+		Issuer:     issuer,
+		Signer:     key,
+		SignerCert: cert,
+		Lookup:     lookupStatus,
+		Period:     period,
+	}, nil
+}
+
+func firstOf(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
@@ -0,0 +1,279 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/profiling"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/netlog"
+)
+
+// In manual mode, well-formed requests are queued until an admin principal
+// approves or denies them. The requester polls, using the request ID, to obtain
+// the outcome. Decided requests are discarded once the outcome is delivered.
+//
+// The queue is bounded, both overall and for each requesting principal, with
+// anonymous requesters counted together. Requests left undecided for longer
+// than the -pendingttl option are denied, and outcomes not collected within
+// that time are discarded. The queue is kept only in memory, so a restart
+// drops every queued request. Polls for a request that is no longer queued get
+// a "no such request" error, which clients treat as final, so requesters must
+// submit again.
+
+// maxPollWait bounds how long a poll with the wait flag will block.
+const maxPollWait = 60 * time.Second
+
+// maxPending bounds the number of queued requests, and maxPendingPerPeer the
+// number for any one requesting principal.
+const (
+	maxPending        = 1000
+	maxPendingPerPeer = 10
+)
+
+type pendingRequest struct {
+	id      string
+	csr     *csrInfo
+	details string
+	peer    string
+	expires time.Time
+	decided bool
+	done    chan struct{}
+	resp    *taoca.Response
+}
+
+var pendingLock = &sync.Mutex{}
+var pending = make(map[string]*pendingRequest)
+
+// errQueueFull is returned by enqueue when the queue, or the requester's share
+// of it, is full.
+var errQueueFull = errors.New("too many requests are pending approval")
+
+// pendingTTL returns how long requests may wait for a decision, and outcomes
+// may wait to be collected.
+func pendingTTL() time.Duration {
+	ttl, err := time.ParseDuration(*options.String["pendingttl"])
+	options.FailIf(err, "bad -pendingttl option")
+	return ttl
+}
+
+// enqueue adds a request to the queue of requests awaiting approval.
+func enqueue(c *csrInfo, details string) (*pendingRequest, error) {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	peer := c.rec.Peer
+	if len(pending) >= maxPending {
+		return nil, errQueueFull
+	}
+	n := 0
+	for _, p := range pending {
+		if p.peer == peer {
+			n++
+		}
+	}
+	if n >= maxPendingPerPeer {
+		return nil, errQueueFull
+	}
+	var id string
+	for {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			// Fall back on the serial number, which is already unique.
+			b = []byte(fmt.Sprintf("%016x", c.serial))
+		}
+		id = hex.EncodeToString(b)
+		if pending[id] == nil {
+			break
+		}
+	}
+	p := &pendingRequest{
+		id:      id,
+		csr:     c,
+		details: details,
+		peer:    peer,
+		expires: time.Now().Add(pendingTTL()),
+		done:    make(chan struct{}),
+	}
+	pending[id] = p
+	return p, nil
+}
+
+// expirePending periodically denies requests that have waited too long for a
+// decision, and discards outcomes that have waited too long to be collected.
+func expirePending() {
+	period := pendingTTL() / 10
+	if period < time.Minute {
+		period = time.Minute
+	}
+	for range time.Tick(period) {
+		now := time.Now()
+		var expired []*pendingRequest
+		pendingLock.Lock()
+		for id, p := range pending {
+			if now.Before(p.expires) {
+				continue
+			}
+			if p.decided {
+				delete(pending, id)
+			} else {
+				p.decided = true
+				expired = append(expired, p)
+			}
+		}
+		pendingLock.Unlock()
+		for _, p := range expired {
+			fmt.Printf("Request %s expired awaiting approval.\n", p.id)
+			netlog.Log("https_ca: request %s for ou=%q cn=%q expired", p.id, p.csr.ou, p.csr.cn)
+			finish(p, denialResponse(p.csr.rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request expired awaiting approval"))
+		}
+	}
+}
+
+// finish delivers the outcome of a decided request to any waiting poll, and
+// keeps it a while longer for later polls.
+func finish(p *pendingRequest, resp *taoca.Response) {
+	pendingLock.Lock()
+	p.resp = resp
+	p.expires = time.Now().Add(pendingTTL())
+	pendingLock.Unlock()
+	close(p.done)
+}
+
+func doPoll(conn *tao.Conn, req *taoca.Request) bool {
+	pendingLock.Lock()
+	p := pending[req.GetRequestId()]
+	pendingLock.Unlock()
+	if p == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "no such request; it may have expired, been collected, or been lost in a restart")
+		return false
+	}
+	// Anyone holding the ID of an anonymous request may poll for it.
+	if p.csr.peer != nil {
+		if conn.Peer() == nil || (!p.csr.peer.Identical(*conn.Peer()) && !isAdmin(*conn.Peer())) {
+			doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+			return false
+		}
+	}
+
+	if req.GetWait() {
+		select {
+		case <-p.done:
+		case <-time.After(maxPollWait):
+		}
+	}
+
+	select {
+	case <-p.done:
+		pendingLock.Lock()
+		delete(pending, p.id)
+		pendingLock.Unlock()
+		sendResponse(conn, p.resp)
+		return p.resp.GetStatus() == taoca.ResponseStatus_TAOCA_OK
+	default:
+		status := taoca.ResponseStatus_TAOCA_PENDING
+		sendResponse(conn, &taoca.Response{Status: &status, RequestId: proto.String(p.id)})
+		return true
+	}
+}
+
+func doListPending(conn *tao.Conn, req *taoca.Request) bool {
+	if conn.Peer() == nil || !isAdmin(*conn.Peer()) {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+		return false
+	}
+	status := taoca.ResponseStatus_TAOCA_OK
+	resp := &taoca.Response{Status: &status}
+	for _, p := range undecided() {
+		resp.Pending = append(resp.Pending, &taoca.PendingRequest{
+			RequestId: proto.String(p.id),
+			Details:   proto.String(p.details),
+		})
+	}
+	sendResponse(conn, resp)
+	return true
+}
+
+func doDecide(conn *tao.Conn, req *taoca.Request) bool {
+	if conn.Peer() == nil || !isAdmin(*conn.Peer()) {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+		return false
+	}
+	resp, err := decide(req.GetRequestId(), req.GetApprove(), conn.Peer().String())
+	if err != nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, err.Error())
+		return false
+	}
+
+	status := taoca.ResponseStatus_TAOCA_OK
+	if resp.GetStatus() != taoca.ResponseStatus_TAOCA_OK && req.GetApprove() {
+		// Issuance failed; report that to the admin as well as the requester.
+		status = resp.GetStatus()
+		sendResponse(conn, &taoca.Response{Status: &status, ErrorDetail: resp.ErrorDetail})
+		return false
+	}
+	sendResponse(conn, &taoca.Response{Status: &status})
+	return true
+}
+
+// decide approves or denies a pending request on behalf of an admin, and
+// returns the response to be delivered to the requester.
+func decide(id string, approve bool, admin string) (*taoca.Response, error) {
+	pendingLock.Lock()
+	p := pending[id]
+	if p == nil || p.decided {
+		pendingLock.Unlock()
+		return nil, fmt.Errorf("no such pending request")
+	}
+	p.decided = true
+	pendingLock.Unlock()
+
+	c := p.csr
+	var resp *taoca.Response
+	if approve {
+		fmt.Printf("Request %s approved by %s. Issuing certificate.\n", p.id, admin)
+		netlog.Log("https_ca: request %s for ou=%q cn=%q approved by %s", p.id, c.ou, c.cn, admin)
+		T := profiling.NewTrace(10, 1)
+		T.Start()
+		resp = issue(c, cpsTemplate+cpsManual, T)
+	} else {
+		fmt.Printf("Request %s denied by %s.\n", p.id, admin)
+		netlog.Log("https_ca: request %s for ou=%q cn=%q denied by %s", p.id, c.ou, c.cn, admin)
+		resp = denialResponse(c.rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+	}
+	finish(p, resp)
+	return resp, nil
+}
+
+// undecided returns the pending requests that have not yet been decided.
+func undecided() []*pendingRequest {
+	pendingLock.Lock()
+	defer pendingLock.Unlock()
+	var list []*pendingRequest
+	for _, p := range pending {
+		if !p.decided {
+			list = append(list, p)
+		}
+	}
+	return list
+}
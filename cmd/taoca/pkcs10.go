@@ -0,0 +1,73 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/kevinawalsh/taoca"
+)
+
+// parsePKCS10 converts a DER-encoded PKCS#10 certificate request to the
+// equivalent CSR, after checking the self-signature as proof that the requester
+// possesses the private key. The resulting CSR is then subject to the same
+// checks as any other.
+func parsePKCS10(der []byte) (*taoca.CSR, error) {
+	req, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := req.CheckSignature(); err != nil {
+		return nil, err
+	}
+	// Check the key first, for a clearer error than tao.FromX509 would give.
+	if err := checkKey(req.PublicKey); err != nil {
+		return nil, err
+	}
+	// tao.FromX509 only looks at the public key.
+	key, err := tao.FromX509(&x509.Certificate{PublicKey: req.PublicKey})
+	if err != nil {
+		return nil, fmt.Errorf("unsupported public key: %s", err)
+	}
+	keydata, err := proto.Marshal(tao.MarshalVerifierProto(key))
+	if err != nil {
+		return nil, err
+	}
+	n := req.Subject
+	name := &taoca.X509Details{
+		CommonName:         proto.String(n.CommonName),
+		Country:            proto.String(firstOf(n.Country)),
+		State:              proto.String(firstOf(n.Province)),
+		City:               proto.String(firstOf(n.Locality)),
+		Organization:       proto.String(firstOf(n.Organization)),
+		OrganizationalUnit: proto.String(firstOf(n.OrganizationalUnit)),
+		DnsName:            req.DNSNames,
+	}
+	for _, ip := range req.IPAddresses {
+		name.IpAddress = append(name.IpAddress, ip.String())
+	}
+	for _, u := range req.URIs {
+		name.Uri = append(name.Uri, u.String())
+	}
+	return &taoca.CSR{
+		PublicKey: keydata,
+		Name:      name,
+		Years:     proto.Int32(1),
+		IsCa:      proto.Bool(false),
+	}, nil
+}
@@ -0,0 +1,57 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// profiles holds the certificate profiles that CSRs can request.
+var profiles map[string]*policy.Profile
+
+// profileName returns the profile requested by a CSR, for display.
+func profileName(csr *taoca.CSR) string {
+	if name := csr.GetProfile(); name != "" {
+		return name
+	}
+	return "(default)"
+}
+
+// explainProfile checks whether guard g allows prin to use a profile, and
+// explains the decision.
+func explainProfile(g tao.Guard, prin auth.Prin, name string) *policy.Explanation {
+	if e := policy.Explain(g, prin, "UseProfile", nil); e.Allowed {
+		return e
+	}
+	return policy.Explain(g, prin, "UseProfile", []string{name})
+}
+
+// applyProfile sets the key usages for a certificate and removes any optional
+// extensions the profile does not allow.
+func applyProfile(template *x509.Certificate, p *policy.Profile) {
+	template.KeyUsage = p.KeyUsage
+	template.ExtKeyUsage = p.ExtKeyUsage
+	if !p.Allows("crl") {
+		template.CRLDistributionPoints = nil
+	}
+	if !p.Allows("ocsp") {
+		template.OCSPServer = nil
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca/netlog"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// The certificate-granting policy is reloaded on SIGHUP, or when the policy
+// file changes. The new policy is swapped in only if it loads without error,
+// otherwise the old policy remains in effect. Rules learned using the -learn
+// option, and not since promoted or discarded, are carried over to the new
+// policy. See learn.go.
+
+// guardLock protects guard, lifetimes, subsidiaries, limits, keyPolicy,
+// policyHash, and knownHashes.
+var guardLock = &sync.RWMutex{}
+
+// lifetimes holds the policy limits on certificate validity periods.
+var lifetimes []*policy.Lifetime
+
+// subsidiaries holds the policy constraints on subsidiary CA certificates.
+var subsidiaries []*policy.Subsidiary
+
+// limits holds the policy rate limits and quotas.
+var limits []*policy.Limit
+
+// keyPolicy holds the policy restrictions on subject keys.
+var keyPolicy policy.KeyPolicy
+
+// policyHash is the SHA-256 hash of the policy file contents for guard.
+var policyHash []byte
+
+// currentGuard returns the guard for the certificate-granting policy now in
+// effect.
+func currentGuard() tao.Guard {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	return guard
+}
+
+// policyVersion identifies the certificate-granting policy that made a
+// decision, so the notice for a certificate names that policy even if another
+// is loaded before the certificate is signed.
+type policyVersion struct {
+	hash string // hex SHA-256 hash of the policy file
+	cps  string // certification practices statement, see policyCPS
+}
+
+// currentPolicyVersion returns the certificate-granting policy now in effect.
+func currentPolicyVersion() policyVersion {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	return lockedPolicyVersion()
+}
+
+// lockedPolicyVersion is like currentPolicyVersion, but callers must hold
+// guardLock.
+func lockedPolicyVersion() policyVersion {
+	return policyVersion{hash: fmt.Sprintf("%x", policyHash), cps: policyCPS(guard)}
+}
+
+// maxLifetime returns the policy limit on the validity period of a certificate
+// for the given OU and CN requested by prin, or zero if there is none.
+func maxLifetime(prin auth.Prin, ou, cn string) time.Duration {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	return policy.MaxLifetime(lifetimes, prin, ou, cn)
+}
+
+// subsidiaryRule returns the policy constraints for a subsidiary CA certificate
+// requested by prin, or nil if there are none.
+func subsidiaryRule(prin auth.Prin) *policy.Subsidiary {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	return policy.FindSubsidiary(subsidiaries, prin)
+}
+
+// checkKey returns an error describing why the policy does not allow a subject
+// key to be certified, if it does not.
+func checkKey(pub crypto.PublicKey) error {
+	guardLock.RLock()
+	defer guardLock.RUnlock()
+	return keyPolicy.Check(pub)
+}
+
+// hashPolicy returns the SHA-256 hash of a policy file.
+func hashPolicy(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// loadPolicy loads the policy file and, if it is valid, makes it the policy in
+// effect.
+func loadPolicy(path string) error {
+	hash, err := hashPolicy(path)
+	if err != nil {
+		return err
+	}
+	p, err := policy.LoadPolicy(path)
+	if err != nil {
+		return err
+	}
+	g := p.Guard
+	guardLock.Lock()
+	defer guardLock.Unlock()
+	known := knownHashes
+	if learnMode {
+		if known, err = loadLearned(); err != nil {
+			return fmt.Errorf("can't load learned rules: %s", err)
+		}
+	}
+	for rule := range known {
+		if err := g.AddRule(rule); err != nil {
+			return fmt.Errorf("can't add learned rule %s: %s", rule, err)
+		}
+	}
+	// The learned rules are swapped in only along with the guard holding them.
+	old := policyHash
+	guard, lifetimes, subsidiaries, limits, policyHash = g, p.Lifetimes, p.Subsidiaries, p.Limits, hash
+	knownHashes = known
+	keyPolicy = p.Keys
+	if old == nil {
+		fmt.Printf("Loaded certificate-granting policy %x\n", hash)
+		netlog.Log("https_ca: loaded policy %x", hash)
+	} else {
+		fmt.Printf("Reloaded certificate-granting policy %x (was %x)\n", hash, old)
+		netlog.Log("https_ca: reloaded policy old=%x new=%x", old, hash)
+	}
+	return nil
+}
+
+// reloadPolicy reloads the policy file, keeping the old policy if the new one
+// is not valid.
+func reloadPolicy(path string) error {
+	err := loadPolicy(path)
+	if err != nil {
+		guardLock.RLock()
+		old := policyHash
+		guardLock.RUnlock()
+		fmt.Printf("error reloading policy, keeping policy %x: %s\n", old, err)
+		netlog.Log("https_ca: policy reload failed, keeping policy %x: %s", old, err)
+		return err
+	}
+	// The new limits may count certificates pruned under the old ones.
+	rebuildUsage()
+	return nil
+}
+
+// watchPolicy reloads the policy file on SIGHUP, and whenever polling finds
+// that the file has changed. It does not return.
+func watchPolicy(path string) {
+	period, err := time.ParseDuration(*options.String["policyperiod"])
+	options.FailIf(err, "bad -policyperiod option")
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	tick := time.Tick(period)
+	var failed []byte
+	for {
+		select {
+		case <-hup:
+			fmt.Printf("Received SIGHUP, reloading policy\n")
+			reloadPolicy(path)
+		case <-tick:
+			hash, err := hashPolicy(path)
+			if err != nil {
+				fmt.Printf("error checking policy: %s\n", err)
+				continue
+			}
+			guardLock.RLock()
+			changed := string(hash) != string(policyHash)
+			guardLock.RUnlock()
+			// Don't retry a bad policy file until it changes again.
+			if changed && string(hash) != string(failed) {
+				if err := reloadPolicy(path); err != nil {
+					failed = hash
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/netlog"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// Admin principals may revoke any certificate. Other principals may only revoke
+// certificates they requested.
+var admins []auth.Prin
+
+func loadAdmins(path string) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return
+	}
+	var err error
+	admins, err = policy.LoadPrincipals(path)
+	options.FailIf(err, "Can't load admin principals")
+	fmt.Printf("Loaded %d admin principals from %s\n", len(admins), path)
+}
+
+func isAdmin(p auth.Prin) bool {
+	for _, a := range admins {
+		if a.Identical(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// joint-iso-itu-t(2) ds(5) certificateExtension(29) cRLReasons(21)
+var idCRLReason = asn1.ObjectIdentifier{2, 5, 29, 21}
+
+var crlLock = &sync.RWMutex{}
+var crl []byte
+
+// crlUpdateLock is held while a CRL is built, signed and installed, so that an
+// older CRL can't replace a newer one.
+var crlUpdateLock = &sync.Mutex{}
+
+// updateCRL signs a fresh CRL listing all revoked certificates.
+func updateCRL() error {
+	crlUpdateLock.Lock()
+	defer crlUpdateLock.Unlock()
+	var revoked []pkix.RevokedCertificate
+	for _, r := range issued.Revoked() {
+		rc := pkix.RevokedCertificate{
+			SerialNumber:   big.NewInt(r.Serial),
+			RevocationTime: r.Revoked,
+		}
+		if r.Reason != 0 {
+			reason, err := asn1.Marshal(asn1.Enumerated(r.Reason))
+			if err != nil {
+				return err
+			}
+			rc.Extensions = []pkix.Extension{{Id: idCRLReason, Value: reason}}
+		}
+		revoked = append(revoked, rc)
+	}
+	period, err := time.ParseDuration(*options.String["crlperiod"])
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	der, err := caKeys.SigningKey.CreateCRL(caKeys.Cert["default"], revoked, now, now.Add(2*period))
+	if err != nil {
+		return err
+	}
+	crlLock.Lock()
+	crl = der
+	crlLock.Unlock()
+	return nil
+}
+
+// refreshCRL periodically signs a fresh CRL, even if nothing has been revoked,
+// so that relying parties never see a stale one.
+func refreshCRL() {
+	period, err := time.ParseDuration(*options.String["crlperiod"])
+	options.FailIf(err, "bad -crlperiod option")
+	for range time.Tick(period) {
+		if err := updateCRL(); err != nil {
+			fmt.Printf("error updating CRL: %s\n", err)
+		}
+	}
+}
+
+func serveCRL(w http.ResponseWriter, req *http.Request) {
+	crlLock.RLock()
+	der := crl
+	crlLock.RUnlock()
+	if der == nil {
+		http.Error(w, "CRL not available", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(der)
+}
+
+func doRevoke(conn *tao.Conn, req *taoca.Request) bool {
+	if req.Revocation == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "missing revocation")
+		return false
+	}
+	serial := req.Revocation.GetSerialNumber()
+	reason := int(req.Revocation.GetReason())
+
+	if conn.Peer() == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "anonymous request is denied")
+		return false
+	}
+	peer := conn.Peer().String()
+
+	rec := issued.Lookup(serial)
+	if rec == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "no such certificate")
+		return false
+	}
+	if rec.Peer != peer && !isAdmin(*conn.Peer()) {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+		return false
+	}
+
+	if err := revoke(rec, reason, peer); err != nil {
+		doError(conn, err, taoca.ResponseStatus_TAOCA_ERROR, "failed to record revocation")
+		return false
+	}
+
+	status := taoca.ResponseStatus_TAOCA_OK
+	sendResponse(conn, &taoca.Response{Status: &status})
+	return true
+}
+
+// revoke records the revocation of an issued certificate, if it has not already
+// been revoked, and refreshes the CRL.
+func revoke(rec *issuance.Record, reason int, peer string) error {
+	serial := rec.Serial
+	if err := issued.Revoke(serial, reason, peer); err == issuance.ErrAlreadyRevoked {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fmt.Printf("Revoked certificate %d for ou=%q cn=%q\n", serial, rec.OrganizationalUnit, rec.CommonName)
+	netlog.Log("https_ca: revoked certificate %d for ou=%q cn=%q by %s", serial, rec.OrganizationalUnit, rec.CommonName, peer)
+	if err := updateCRL(); err != nil {
+		fmt.Printf("error updating CRL: %s\n", err)
+	}
+	return nil
+}
@@ -22,7 +22,10 @@
 //   process has been completed. This is, in effect, what a real CA does. A
 //   self-signed certificate for the signing key needs is also generated. This
 //   needs to be installed into browsers manually. This mode need not run under
-//   a Tao host, as it makes no use of Tao services.
+//   a Tao host, as it makes no use of Tao services. Requests are queued, and
+//   the requester gets a request ID it can use to poll for the outcome. Admin
+//   principals list the queue and approve or deny requests, e.g. using the
+//   taoca_pending command.
 //
 // * Automated (with policy)
 //   In this mode, we rely on the services of a Tao host to seal the private key
@@ -53,11 +56,71 @@
 //   specify how those trusted servers can be instantiated, namely, by running
 //   on a trusted host. Rule 0 specifies that only trusted instances can claim
 //   certificates using the given x509 OrganizationalUnit and CommonName values.
+//   Each subject alternative name (DNS name, IP address, or URI) in a request
+//   is checked in the same way, taking the place of the CommonName.
+//   The policy can also limit the validity period of certificates. Requests for
+//   longer periods are shortened to fit, or denied with the -rejectlong option.
+//   Similarly, the policy can set name constraints and path length limits for
+//   certificates issued to subsidiary CAs, and it can set rate limits and
+//   quotas. Requests over a limit are refused with a hint of when to retry.
+//   Finally, the policy can restrict the algorithm and strength of subject keys.
+//   Only ECDSA P-256 keys can be certified, and the CA's own keys are always
+//   ECDSA P-256, since those are the only keys the Tao key layer generates.
+//   For each request, the CA works out which rules authorized it (the ACL entry,
+//   or the rules used in the datalog derivation) and records these in the
+//   issuance database and the user notice. For a denial, the closest rules are
+//   recorded instead, and with the -explain option, returned to the client.
+//
+// A CSR can name a certificate profile, such as "server", "client",
+// "codesigning", or "ca", which sets the key usages, maximum validity, and
+// allowed extensions of the certificate. Profiles can be added or redefined in
+// the "profiles" file in the keys directory. In automated mode, the policy must
+// allow the requesting principal to use the profile, e.g. using a rule like
+// Authorized(P, "UseProfile", "client").
+//   The policy file is reloaded on SIGHUP, or when it changes. If the new
+//   policy fails to load, the old policy remains in effect.
+//
+// Certificates can be revoked by the principal that requested them, or by any
+// of the admin principals listed in the "admins" file in the keys directory.
+// A CRL signed by the CA is refreshed periodically and after every revocation,
+// and it can be served over HTTP using the -crladdr option. Similarly, an OCSP
+// responder, using a delegated signing key certified by the CA, can be served
+// over HTTP using the -ocspaddr option.
+//
+// Every issued certificate is appended to a Merkle tree log, and the response
+// includes proof of inclusion under a tree head signed by the CA. Consistency
+// proofs between tree heads let auditors check that the log is append-only.
 //
 // Requests:
 //   CSR <name, is_ca, expiration, etc.>
+//   PKCS10 <DER-encoded PKCS#10 CertificationRequest>
+// A CSR must be sent serialized, along with a signature over exactly those
+// bytes, made using the private key corresponding to the public key to be
+// certified. A CSR can
+// also carry a Tao delegation showing that the key speaks for the requesting
+// principal. With the -bindkey option, such a delegation is required.
+//   REVOKE <serial, reason>
+//   LOG_CONSISTENCY <first, second>
+//   POLL <request_id, wait>
+//   LIST_PENDING
+//   DECIDE <request_id, approve>
+// An ACME (RFC 8555) front-end can also be served over HTTP using the -acmeaddr
+// option. See acme.go for details.
+// An admin console can be served over HTTPS using the -adminaddr option. See
+// console.go for details.
+//
+// Each certificate links to a certification practices statement (CPS) and a
+// user notice, published as content-addressed files in the -docdir directory
+// and served at the -docurl location. The CA can serve these itself over HTTPS
+// using the -docaddr option. See docs.go for details. A machine-readable JSON
+// version of the user notice, with the requesting principal's manifest, the
+// policy hash, and the rules that authorized the request, is published
+// alongside, and linked from the certificate by a second CPS qualifier in the
+// certificate policies extension.
 // Responses:
-//   OK <x509cert>
+//   OK [ <x509cert> | <none> ]
+//   PENDING <request_id>
+//   RATE_LIMITED <msg, retry_after_seconds>
 //   ERROR <msg>
 
 package main
@@ -65,14 +128,17 @@ package main
 import (
 	"crypto/rand"
 	"crypto/sha256"
+	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"path"
 	"strings"
-	"sync"
+	"time"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/jlmucb/cloudproxy/go/tao"
@@ -82,9 +148,11 @@ import (
 	"github.com/jlmucb/cloudproxy/go/util/verbose"
 	"github.com/kevinawalsh/profiling"
 	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/issuance"
 	"github.com/kevinawalsh/taoca/netlog"
 	"github.com/kevinawalsh/taoca/policy"
 	"github.com/kevinawalsh/taoca/rendezvous"
+	"github.com/kevinawalsh/taoca/util/x509txt"
 )
 
 var opts = []options.Option{
@@ -92,8 +160,12 @@ var opts = []options.Option{
 	{"host", "0.0.0.0", "<address>", "Address for listening", "all,persistent"},
 	{"port", "8143", "<port>", "Port for listening", "all,persistent"},
 	{"manual", false, "", "Require manual approval of requests", "all,persistent"},
-	{"learn", false, "", "Auto-learn program hashes", "all,persistent"},
-	{"init", false, "", "Initialize fresh signing keys", "all"},
+	{"pendingttl", "72h", "<duration>", "How long requests may await manual approval, and outcomes await collection", "all,persistent"},
+	{"learn", false, "", "Auto-learn program hashes, pending review with taoca_learned", "all,persistent"},
+	{"rejectlong", false, "", "Deny requests with validity periods exceeding the policy maximum, instead of shortening them", "all,persistent"},
+	{"explain", false, "", "Explain policy decisions in the details of denial responses", "all,persistent"},
+	{"bindkey", false, "", "Require a Tao delegation binding each subject key to the requesting principal", "all,persistent"},
+	{"init", false, "", "Initialize fresh (ECDSA P-256) signing keys", "all"},
 	{"name", "https ca", "<name>", "Register with rendezvous using this name", "all,persistent"},
 	{"root", false, "", "Act as a root CA, with a self-signed certificate", "all,persistent"},
 	{"subsidiary", "", "<parentname>", "Act as a subsidiary CA, with a certificate signed by parent CA", "all,persistent"},
@@ -101,6 +173,20 @@ var opts = []options.Option{
 	{"keys", "", "<dir>", "Directory for storing keys and associated certificates", "all,persistent"},
 	{"docdir", "/etc/tao/https/docs/security/", "<dir>", "Directory for publishing CPS and unotice documents", "all,persistent"},
 	{"docurl", "https://0.0.0.0:8443/security/", "<url>", "Base url at which published CPS and unotice documents are served", "all,persistent"},
+	{"docaddr", "", "<address>", "Address for serving published CPS and unotice documents over HTTPS", "all,persistent"},
+	{"crladdr", "", "<address>", "Address for serving the CRL over HTTP", "all,persistent"},
+	{"crlurl", "", "<url>", "URL at which the CRL is served, for inclusion in certificates", "all,persistent"},
+	{"crlperiod", "24h", "<duration>", "How often to sign a fresh CRL", "all,persistent"},
+	{"ocspaddr", "", "<address>", "Address for serving OCSP responses over HTTP", "all,persistent"},
+	{"ocspurl", "", "<url>", "URL of the OCSP responder, for inclusion in certificates", "all,persistent"},
+	{"ocspperiod", "1h", "<duration>", "How long OCSP responses remain valid", "all,persistent"},
+	{"policyperiod", "1m", "<duration>", "How often to check the policy file for changes", "all,persistent"},
+	{"logperiod", "1h", "<duration>", "How often to sign a fresh certificate log tree head", "all,persistent"},
+	{"acmeaddr", "", "<address>", "Address for serving the ACME front-end over HTTP", "all,persistent"},
+	{"acmeurl", "", "<url>", "Base URL of the ACME front-end, as seen by clients", "all,persistent"},
+	{"acmehttp01", false, "", "Accept ACME http-01 challenges, bypassing policy (for testing only!)", "all,persistent"},
+	{"adminaddr", "", "<address>", "Address for serving the admin console over HTTPS", "all,persistent"},
+	{"adminroots", "", "<file>", "PEM file of CA certificates trusted to certify admin client certificates", "all,persistent"},
 	{"config", "/etc/tao/https_ca/ca.config", "<file>", "Location for storing configuration", "all"},
 	{"stats", "", "", "rate to print status updates", "all,persistent"},
 	{"profile", "", "", "filename to capture cpu profile", "all,persistent"},
@@ -136,15 +222,15 @@ var guard tao.Guard
 var learnMode bool
 var knownHashes = make(map[string]bool)
 
-var lock = &sync.RWMutex{}
+var issued *issuance.DB
 
-func printRequest(req *taoca.Request, subjectKey *tao.Verifier, serial int64, peer string) {
+func describeRequest(req *taoca.Request, subjectKey *tao.Verifier, serial int64, peer string) string {
 	t := "Server (can't sign certificates)"
 	if *req.CSR.IsCa {
 		t = "Certificate Authority (can sign certificates)"
 	}
 	name := req.CSR.Name
-	fmt.Printf("\n"+
+	return fmt.Sprintf("\n"+
 		"A new Certificate Signing Request has been received:\n"+
 		"  Country: %s\n"+
 		"  Province: %s\n"+
@@ -152,27 +238,91 @@ func printRequest(req *taoca.Request, subjectKey *tao.Verifier, serial int64, pe
 		"  Organization: %s\n"+
 		"  Organizational Unit: %s\n"+
 		"  Common Name: %s\n"+
-		"  Validity Period: %d years\n"+
+		"  Alternative Names: %s\n"+
+		"  Validity Period: %v\n"+
 		"  Type: %s\n"+
+		"  Profile: %s\n"+
 		"  Serial: %d\n"+
 		"  Public Key Principal: %s\n"+
 		"  Requesting Principal: %s\n"+
 		"\n",
 		*name.Country, *name.State, *name.City,
 		*name.Organization, *name.OrganizationalUnit, *name.CommonName,
-		*req.CSR.Years, t, serial, subjectKey.ToPrincipal(), peer)
+		strings.Join(requestedAltNames(name), ", "),
+		req.CSR.Lifetime(), t, profileName(req.CSR), serial, subjectKey.ToPrincipal(), peer)
 }
 
 func doError(ms util.MessageStream, err error, status taoca.ResponseStatus, detail string) {
+	sendResponse(ms, errorResponse(err, status, detail))
+}
+
+func errorResponse(err error, status taoca.ResponseStatus, detail string) *taoca.Response {
 	if err != nil {
 		fmt.Printf("error handling request: %s\n", err)
 	}
 	fmt.Printf("sending error response: status=%s detail=%q\n", status, detail)
-	resp := &taoca.Response{
+	return &taoca.Response{
 		Status:      &status,
 		ErrorDetail: proto.String(detail),
 	}
-	sendResponse(ms, resp)
+}
+
+// doDenial records a denied (or malformed) request in the issuance database,
+// then sends an error response.
+func doDenial(ms util.MessageStream, rec *issuance.Record, err error, status taoca.ResponseStatus, detail string) {
+	sendResponse(ms, denialResponse(rec, err, status, detail))
+}
+
+func denialResponse(rec *issuance.Record, err error, status taoca.ResponseStatus, detail string) *taoca.Response {
+	rec.Time = time.Now()
+	rec.Status = status.String()
+	rec.Detail = detail
+	if err := issued.Append(rec); err != nil {
+		fmt.Printf("error recording denial: %s\n", err)
+	}
+	return errorResponse(err, status, detail)
+}
+
+// doPolicyDenial records and sends a denial for a request the policy does not
+// allow, along with an explanation. The explanation is only sent to the client
+// with the -explain option.
+func doPolicyDenial(ms util.MessageStream, rec *issuance.Record, e *policy.Explanation, detail string) {
+	sendResponse(ms, policyDenialResponse(rec, e, detail))
+}
+
+func policyDenialResponse(rec *issuance.Record, e *policy.Explanation, detail string) *taoca.Response {
+	fmt.Printf("Policy does not allow this request, %s\n", e)
+	rec.Explanation = e.String()
+	if *options.Bool["explain"] {
+		detail += "; " + e.String()
+	}
+	return denialResponse(rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, detail)
+}
+
+// checkProfile checks that a certificate profile suits a request, returning a
+// reason to reject the request if not.
+func checkProfile(prof *policy.Profile, isCA bool, alt *altNames) string {
+	if prof.IsCA != isCA {
+		return "certificate profile does not match is_ca"
+	}
+	if !prof.Allows("altnames") && len(alt.claims()) > 0 {
+		return "certificate profile does not allow alternative names"
+	}
+	return ""
+}
+
+// clampLifetime shortens a validity period to a maximum set by the profile or
+// policy, if the maximum is not zero. With the -rejectlong option, a reason to
+// reject the request is returned instead.
+func clampLifetime(lifetime, max time.Duration, limit string) (time.Duration, string) {
+	if max <= 0 || lifetime <= max {
+		return lifetime, ""
+	}
+	if *options.Bool["rejectlong"] {
+		return lifetime, "validity period exceeds " + limit + " maximum"
+	}
+	fmt.Printf("Shortening validity period from %v to %s maximum %v\n", lifetime, limit, max)
+	return max, ""
 }
 
 func sendResponse(ms util.MessageStream, resp *taoca.Response) {
@@ -225,11 +375,25 @@ func sanitizeURL(s *string, fieldName string, errmsg *string) string {
 }
 
 func publish(doc []byte) (url string, err error) {
+	return publishAs(doc, ".txt")
+}
+
+// publishJSON publishes v, encoded as JSON, in the same way as publish.
+func publishJSON(v interface{}) (url string, err error) {
+	doc, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return
+	}
+	return publishAs(append(doc, '\n'), ".json")
+}
+
+// publishAs publishes doc under its hash, with the given file extension.
+func publishAs(doc []byte, ext string) (url string, err error) {
 	docurl := *options.String["docurl"]
 	docdir := *options.String["docdir"]
 
 	h := sha256.Sum256(doc)
-	p := path.Join(docdir, fmt.Sprintf("%x.txt", h))
+	p := path.Join(docdir, fmt.Sprintf("%x%s", h, ext))
 	err = util.WritePath(p, doc, 0777, 0666)
 	if err != nil {
 		return
@@ -238,10 +402,59 @@ func publish(doc []byte) (url string, err error) {
 	if !strings.HasSuffix(docurl, "/") {
 		docurl += "/"
 	}
-	url = fmt.Sprintf("%s%x.txt", docurl, h)
+	url = fmt.Sprintf("%s%x%s", docurl, h, ext)
 	return
 }
 
+// policyCPS returns the certification practices statement for automated mode,
+// using guard g.
+func policyCPS(g tao.Guard) string {
+	var cps string
+	if _, ok := g.(*tao.ACLGuard); ok {
+		cps = cpsTemplate + cpsACL
+	} else {
+		cps = cpsTemplate + cpsDatalog
+	}
+	return cps + "\n" + g.String()
+}
+
+// newTemplate prepares a certificate template for a subject, valid from now
+// until the given lifetime has elapsed.
+func newTemplate(name *pkix.Name, alt *altNames, isCA bool, serial int64, lifetime time.Duration, ext pkix.Extension) *x509.Certificate {
+	template := caKeys.SigningKey.X509Template(name, ext)
+	template.NotBefore = time.Now()
+	template.NotAfter = template.NotBefore.Add(lifetime)
+	template.IsCA = isCA
+	template.DNSNames = alt.dns
+	template.IPAddresses = alt.ips
+	template.URIs = alt.uris
+	if crlurl := *options.String["crlurl"]; crlurl != "" {
+		template.CRLDistributionPoints = []string{crlurl}
+	}
+	if ocspurl := *options.String["ocspurl"]; ocspurl != "" {
+		template.OCSPServer = []string{ocspurl}
+	}
+	template.SerialNumber.SetInt64(serial)
+	return template
+}
+
+// newSerial generates a random, positive serial number that has not been used
+// for any previously issued certificate.
+func newSerial() (int64, error) {
+	for {
+		var serial int64
+		if err := binary.Read(rand.Reader, binary.LittleEndian, &serial); err != nil {
+			return 0, err
+		}
+		if serial < 0 {
+			serial = ^serial
+		}
+		if serial != 0 && issued.Lookup(serial) == nil {
+			return serial, nil
+		}
+	}
+}
+
 func doResponseWithStats(conn *tao.Conn) {
 	op := profiling.NewOp()
 	conn.T = profiling.NewTrace(2, 1)
@@ -287,6 +500,57 @@ func doResponse(conn *tao.Conn) bool {
 	}
 	T.Sample("got peer") // 2
 
+	switch req.GetType() {
+	case taoca.RequestType_TAOCA_REVOKE:
+		return doRevoke(conn, &req)
+	case taoca.RequestType_TAOCA_LOG_CONSISTENCY:
+		return doConsistency(conn, &req)
+	case taoca.RequestType_TAOCA_POLL:
+		return doPoll(conn, &req)
+	case taoca.RequestType_TAOCA_LIST_PENDING:
+		return doListPending(conn, &req)
+	case taoca.RequestType_TAOCA_DECIDE:
+		return doDecide(conn, &req)
+	}
+	if req.SerializedCsr != nil {
+		if req.CSR != nil || req.Pkcs10 != nil {
+			doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "more than one CSR given")
+			return false
+		}
+		req.CSR = new(taoca.CSR)
+		if err := proto.Unmarshal(req.SerializedCsr, req.CSR); err != nil {
+			doError(conn, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "can't unmarshal CSR")
+			return false
+		}
+	}
+	pkcs10 := req.Pkcs10 != nil
+	if pkcs10 {
+		if req.CSR != nil {
+			doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "both CSR and PKCS#10 request given")
+			return false
+		}
+		csr, err := parsePKCS10(req.Pkcs10)
+		if err != nil {
+			doError(conn, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "invalid PKCS#10 request: "+err.Error())
+			return false
+		}
+		req.CSR = csr
+	}
+	if req.CSR == nil || req.CSR.Name == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "missing CSR")
+		return false
+	}
+
+	rec := &issuance.Record{
+		Subject:            x509txt.RDNString(*NewX509Name(req.CSR.Name)),
+		OrganizationalUnit: req.CSR.Name.GetOrganizationalUnit(),
+		CommonName:         req.CSR.Name.GetCommonName(),
+		AltNames:           requestedAltNames(req.CSR.Name),
+		Peer:               peer,
+		IsCA:               req.CSR.GetIsCa(),
+		Profile:            req.CSR.GetProfile(),
+	}
+
 	var errmsg string
 
 	// Check whether the CSR is well-formed
@@ -297,150 +561,321 @@ func doResponse(conn *tao.Conn) bool {
 	sanitize(name.Organization, "Organization", &errmsg)
 	ou := sanitize(name.OrganizationalUnit, "OrganizationalUnit", &errmsg)
 	cn := sanitize(name.CommonName, "CommonName", &errmsg)
-	years := *req.CSR.Years
-	if years <= 0 {
+	alt := sanitizeAltNames(name, &errmsg)
+	lifetime := req.CSR.Lifetime()
+	if lifetime <= 0 {
 		errmsg = "invalid validity period"
 	}
 	if errmsg != "" {
-		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, errmsg)
+		doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, errmsg)
+		return false
+	}
+	if req.CSR.GetIsCa() && parentPathLen() == 0 {
+		doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "path length limit forbids subsidiary CAs")
 		return false
 	}
+	var prof *policy.Profile
+	if name := req.CSR.GetProfile(); name != "" {
+		prof = profiles[name]
+		if prof == nil {
+			doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "unknown certificate profile")
+			return false
+		}
+		if errmsg = checkProfile(prof, req.CSR.GetIsCa(), alt); errmsg == "" {
+			lifetime, errmsg = clampLifetime(lifetime, prof.MaxLifetime, "profile")
+		}
+		if errmsg != "" {
+			doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, errmsg)
+			return false
+		}
+	}
 	T.Sample("sanitized") // 3
 
 	var ck tao.CryptoKey
 	if err := proto.Unmarshal(req.CSR.PublicKey, &ck); err != nil {
-		doError(conn, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "can't unmarshal key")
+		doDenial(conn, rec, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "can't unmarshal key")
 		return false
 	}
 	subjectKey, err := tao.UnmarshalVerifierProto(&ck)
 	if err != nil {
-		doError(conn, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "can't unmarshal key")
+		doDenial(conn, rec, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "can't unmarshal key")
+		return false
+	}
+	rec.SubjectKey = subjectKey.ToPrincipal().String()
+	pub, err := x509.ParsePKIXPublicKey(subjectKey.MarshalKey())
+	if err != nil {
+		doDenial(conn, rec, err, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "can't parse key")
+		return false
+	}
+	if err := checkKey(pub); err != nil {
+		doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, err.Error())
+		return false
+	}
+	// A PKCS#10 request carries its own self-signature, checked already.
+	if !pkcs10 {
+		if ok, err := taoca.VerifyCSR(subjectKey, req.SerializedCsr, req.Signature); !ok {
+			doDenial(conn, rec, err, taoca.ResponseStatus_TAOCA_BAD_SIGNATURE, "CSR signature does not verify")
+			return false
+		}
+	}
+	if req.CSR.Delegation != nil {
+		if err := checkDelegation(req.CSR.Delegation, subjectKey, conn.Peer()); err != nil {
+			doDenial(conn, rec, err, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "subject key delegation is not valid")
+			return false
+		}
+	} else if *options.Bool["bindkey"] {
+		doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "subject key delegation is required")
 		return false
 	}
 	T.Sample("got subject") // 4
 
-	// TODO(kwalsh) more robust generation of serial numbers?
-	var serial int64
-	if err := binary.Read(rand.Reader, binary.LittleEndian, &serial); err != nil {
+	serial, err := newSerial()
+	if err != nil {
 		doError(conn, err, taoca.ResponseStatus_TAOCA_ERROR, "could not generate random serial number")
+		return false
 	}
-	if serial < 0 {
-		serial = ^serial
-	}
+	rec.Serial = serial
 	T.Sample("made serial") // 5
 
+	details := describeRequest(&req, subjectKey, serial, peer)
 	if verbose.Enabled {
-		printRequest(&req, subjectKey, serial, peer)
+		fmt.Print(details)
 	}
 
-	var cps, unotice string
-	if manualMode {
-		lock.Lock()
-		var ok string
-		for {
-			ok = options.Confirm("Approve this request?", "no")
-			if ok == "yes" || ok == "no" {
-				break
-			}
-			fmt.Printf("I don't understand %q. Please type yes or no.\n", ok)
-		}
-		lock.Unlock()
+	c := &csrInfo{
+		name:       NewX509Name(req.CSR.Name),
+		isCA:       req.CSR.GetIsCa(),
+		rec:        rec,
+		alt:        alt,
+		subjectKey: subjectKey,
+		serial:     serial,
+		lifetime:   lifetime,
+		profile:    prof,
+		peer:       conn.Peer(),
+		ou:         ou,
+		cn:         cn,
+		requested:  time.Now(),
+	}
 
-		if ok != "yes" {
-			doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+	var cps string
+	if manualMode {
+		// Queue the request for an admin to approve or deny.
+		p, err := enqueue(c, details)
+		if err != nil {
+			resp := denialResponse(rec, nil, taoca.ResponseStatus_TAOCA_RATE_LIMITED, err.Error())
+			resp.RetryAfterSeconds = proto.Int64(int64(maxPollWait / time.Second))
+			sendResponse(conn, resp)
 			return false
 		}
-
-		fmt.Printf("Issuing certificate.\n")
-
-		cps = cpsTemplate + cpsManual
+		fmt.Printf("Request %s is pending approval.\n", p.id)
+		status := taoca.ResponseStatus_TAOCA_PENDING
+		sendResponse(conn, &taoca.Response{Status: &status, RequestId: proto.String(p.id)})
+		return true
 	} else {
 		// Consult guard to enforce policy.
 		if conn.Peer() == nil {
-			doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "anonymous request is denied")
+			doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "anonymous request is denied")
 			return false
 		}
 
 		if learnMode {
-			prin := *conn.Peer()
-			if len(prin.Ext) > 0 {
-				last := prin.Ext[len(prin.Ext)-1]
-				tail := auth.PrinTail{
-					Ext: auth.SubPrin([]auth.PrinExt{last}),
-				}
-				prinHash := fmt.Sprintf("Known(%v)", tail)
-				if !knownHashes[prinHash] {
-					fmt.Printf("Learned: %s\n", prinHash)
-					knownHashes[prinHash] = true
-					if err := guard.AddRule(prinHash); err != nil {
-						fmt.Println("Error adding rule: %s\n", err)
-					}
-				}
+			guardLock.Lock()
+			err := learn(*conn.Peer(), ou, cn)
+			guardLock.Unlock()
+			if err != nil {
+				doError(conn, err, taoca.ResponseStatus_TAOCA_ERROR, "failed to learn program hash")
+				return false
 			}
 		}
 
-		if !guard.IsAuthorized(*conn.Peer(), "ClaimCertificate", []string{*name.OrganizationalUnit, *name.CommonName}) &&
-			!guard.IsAuthorized(*conn.Peer(), "ClaimCertificate", nil) {
-			fmt.Printf("Policy (as follows) does not allow this request\n")
-			fmt.Printf("%s\n", guard.String())
-			doError(conn, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, "request is denied")
+		e, use, v := explainRequest(*conn.Peer(), ou, cn, alt.claims(), prof)
+		if !e.Allowed {
+			doPolicyDenial(conn, rec, e, "request is denied")
 			return false
 		}
+		c.explanation = e
+		if use != nil {
+			if !use.Allowed {
+				doPolicyDenial(conn, rec, use, "certificate profile is not allowed")
+				return false
+			}
+			c.explanation = policy.Merge(e, use)
+		}
 
-		if _, ok := guard.(*tao.ACLGuard); ok {
-			cps = cpsTemplate + cpsACL
-		} else {
-			cps = cpsTemplate + cpsDatalog
+		c.lifetime, errmsg = clampLifetime(c.lifetime, maxLifetime(*conn.Peer(), ou, cn), "policy")
+		if errmsg != "" {
+			doDenial(conn, rec, nil, taoca.ResponseStatus_TAOCA_REQUEST_DENIED, errmsg)
+			return false
 		}
-		cps += "\n" + guard.String()
+
+		if req.CSR.GetIsCa() {
+			c.sub = subsidiaryRule(*conn.Peer())
+		}
+
+		c.limitPrins = []auth.Prin{*conn.Peer()}
+		cps = v.cps
+		c.policyHash = v.hash
 	}
 	T.Sample("authenticated") // 6
 
-	if conn.Peer() != nil {
+	resp := issue(c, cps, T)
+	sendResponse(conn, resp)
+	T.Sample("sent response") // 10
+	//fmt.Println(T)
+	return resp.GetStatus() == taoca.ResponseStatus_TAOCA_OK
+}
+
+// csrInfo holds the details of a well-formed CSR, pending a decision.
+type csrInfo struct {
+	name       *pkix.Name
+	isCA       bool
+	rec        *issuance.Record
+	alt        *altNames
+	subjectKey *tao.Verifier
+	serial     int64
+	lifetime   time.Duration
+	sub        *policy.Subsidiary
+	profile    *policy.Profile
+	peer       *auth.Prin
+	ou, cn     string
+	requested  time.Time
+	policyHash string
+
+	// limitPrins holds the principals whose limits apply to the request. For
+	// ACME orders, acmeAccount holds the account key thumbprint.
+	limitPrins  []auth.Prin
+	acmeAccount string
+
+	explanation *policy.Explanation
+}
+
+// issue signs, records, and logs the certificate for an approved CSR, and
+// builds the response.
+func issue(c *csrInfo, cps string, T *profiling.Trace) *taoca.Response {
+	cert, resp := signAndRecord(c, cps, T)
+	if resp != nil {
+		return resp
+	}
+	inclusion, err := logCertificate(cert.Raw)
+	if err != nil {
+		return errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to log certificate")
+	}
+
+	status := taoca.ResponseStatus_TAOCA_OK
+	resp = &taoca.Response{
+		Status:    &status,
+		Cert:      []*taoca.Cert{&taoca.Cert{X509Cert: cert.Raw}},
+		Inclusion: inclusion,
+	}
+	for _, parent := range caKeys.CertChain("default") {
+		resp.Cert = append(resp.Cert, &taoca.Cert{X509Cert: parent.Raw})
+	}
+	T.Sample("built response") // 9
+
+	return resp
+}
+
+// signAndRecord publishes the documents for an approved CSR, then signs and
+// records the certificate. If c.limitPrins is set, the policy limits for each
+// of those principals are checked first, and limitLock is held until the
+// certificate is recorded. On failure, it returns the response to send instead.
+func signAndRecord(c *csrInfo, cps string, T *profiling.Trace) (*x509.Certificate, *taoca.Response) {
+	if len(c.limitPrins) > 0 {
+		limitLock.Lock()
+		defer limitLock.Unlock()
+	}
+	for _, prin := range c.limitPrins {
+		if l, wait := checkLimits(prin, c.cn); l != nil {
+			fmt.Printf("Request exceeds limit: %s\n", l)
+			resp := denialResponse(c.rec, nil, taoca.ResponseStatus_TAOCA_RATE_LIMITED, "request exceeds limit: "+l.String())
+			resp.RetryAfterSeconds = proto.Int64(int64(wait/time.Second) + 1)
+			return nil, resp
+		}
+	}
+
+	var unotice string
+	if c.acmeAccount != "" {
+		unotice = fmt.Sprintf(unoticeTemplate+
+			"* The certificate was requested using ACME, by the account with key\n"+
+			"  thumbprint %s, and the names were attested by:\n\n   %s\n",
+			c.acmeAccount, c.rec.Peer)
+	} else if c.peer != nil {
 		unotice = fmt.Sprintf(unoticeTemplate+
 			"* The certificate was requested by the following Tao principal:\n\n   %v\n",
-			*conn.Peer())
+			*c.peer)
 	} else {
 		unotice = fmt.Sprintf(unoticeTemplate +
 			"* The certificate was requested anonymously.\n")
 	}
+	if c.explanation != nil {
+		unotice += fmt.Sprintf("\n* The request was %s\n", c.explanation)
+		c.rec.Explanation = c.explanation.String()
+	}
 	cpsUrl, err := publish([]byte(cps))
+	if err != nil {
+		return nil, errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to publish certification practices statement")
+	}
 	unoticeUrl, err := publish([]byte(unotice))
+	if err != nil {
+		return nil, errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to publish user notice")
+	}
+
+	notice := &taoca.Notice{
+		Serial:      c.serial,
+		Principal:   c.rec.Peer,
+		PolicyHash:  c.policyHash,
+		Explanation: c.explanation,
+		Time:        c.requested,
+		CPS:         cpsUrl,
+		UserNotice:  unoticeUrl,
+	}
+	if c.peer != nil {
+		notice.Manifest = tao.DeriveManifest(c.peer)
+	}
+	noticeUrl, err := publishJSON(notice)
+	if err != nil {
+		return nil, errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to publish machine-readable user notice")
+	}
 
 	// ext, err := taoca.NewUserNotice("Hello user, how are you?")
-	ext, err := taoca.NewCertficationPolicy(cpsUrl, unoticeUrl)
+	ext, err := taoca.NewCertficationPolicyWithNotice(cpsUrl, unoticeUrl, noticeUrl)
 	if err != nil {
-		doError(conn, err, taoca.ResponseStatus_TAOCA_ERROR, "failed to generate certificate policy extension")
-		return false
+		return nil, errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to generate certificate policy extension")
 	}
 	T.Sample("made cps") // 7
 
-	netlog.Log("https_ca: issuing certificate for ou=%q cn=%q to %s", ou, cn, peer)
+	if c.acmeAccount != "" {
+		netlog.Log("https_ca: issuing certificate for ou=%q cn=%q to %s via ACME", c.ou, c.cn, c.rec.Peer)
+	} else {
+		netlog.Log("https_ca: issuing certificate for ou=%q cn=%q to %s", c.ou, c.cn, c.rec.Peer)
+	}
 
-	template := caKeys.SigningKey.X509Template(NewX509Name(name), ext)
-	template.IsCA = *req.CSR.IsCa
-	template.SerialNumber.SetInt64(serial)
-	cert, err := caKeys.CreateSignedX509(subjectKey, template, "default")
+	template := newTemplate(c.name, c.alt, c.isCA, c.serial, c.lifetime, ext)
+	if c.profile != nil {
+		applyProfile(template, c.profile)
+	}
+	if template.IsCA {
+		constrainCA(template, c.sub)
+	}
+	cert, err := caKeys.CreateSignedX509(c.subjectKey, template, "default")
 	if err != nil {
-		doError(conn, err, taoca.ResponseStatus_TAOCA_ERROR, "failed to generate certificate")
-		return false
+		return nil, errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to generate certificate")
 	}
 	T.Sample("signed cert") // 8
 
-	status := taoca.ResponseStatus_TAOCA_OK
-	resp := &taoca.Response{
-		Status: &status,
-		Cert:   []*taoca.Cert{&taoca.Cert{X509Cert: cert.Raw}},
+	rec := c.rec
+	rec.Time = time.Now()
+	rec.Status = taoca.ResponseStatus_TAOCA_OK.String()
+	rec.NotBefore = cert.NotBefore
+	rec.NotAfter = cert.NotAfter
+	rec.CPS = cpsUrl
+	rec.UserNotice = unoticeUrl
+	rec.Notice = noticeUrl
+	rec.Cert = cert.Raw
+	if err := issued.Append(rec); err != nil {
+		return nil, errorResponse(err, taoca.ResponseStatus_TAOCA_ERROR, "failed to record certificate")
 	}
-	for _, parent := range caKeys.CertChain("default") {
-		resp.Cert = append(resp.Cert, &taoca.Cert{X509Cert: parent.Raw})
-	}
-	T.Sample("built response") // 9
-
-	sendResponse(conn, resp)
-	T.Sample("sent response") // 10
-	//fmt.Println(T)
-	return true
+	return cert, nil
 }
 
 func main() {
@@ -485,6 +920,11 @@ func main() {
 		options.Fail(nil, "Option -keys or -config is required")
 	}
 	ppath := path.Join(kdir, "policy")
+	dbpath := path.Join(kdir, "issuance")
+	apath := path.Join(kdir, "admins")
+	rpath := path.Join(kdir, "profiles")
+	lpath := path.Join(kdir, "translog")
+	learnedPath = path.Join(kdir, "learned")
 
 	var err error
 
@@ -516,6 +956,8 @@ func main() {
 			}
 		}
 
+		// TODO(kwalsh) choose the signing algorithm here, and in GenerateKeys,
+		// once the Tao key layer can generate keys other than ECDSA P-256.
 		if manualMode {
 			pwd := options.Password("Choose an HTTPS/TLS CA signing key password", "pass")
 			caKeys, err = tao.InitOnDiskPBEKeys(tao.Signing, pwd, kdir, caName)
@@ -566,9 +1008,74 @@ func main() {
 	netlog.Log("https_ca: start")
 	netlog.Log("https_ca: manual? %v", manualMode)
 
+	issued, err = issuance.Open(dbpath)
+	options.FailIf(err, "Can't open issuance database")
+	issued.Watch(countUsage)
+
 	if !manualMode {
-		guard, err = policy.Load(ppath)
+		err = loadPolicy(ppath)
 		options.FailIf(err, "Can't load certificate-granting policy")
+		go watchPolicy(ppath)
+	}
+
+	loadAdmins(apath)
+
+	profiles, err = policy.LoadProfiles(rpath)
+	options.FailIf(err, "Can't load certificate profiles")
+	fmt.Printf("Certificate profiles: %s\n", strings.Join(policy.ProfileNames(profiles), ", "))
+
+	err = openTransLog(lpath)
+	options.FailIf(err, "Can't open certificate log")
+	_, err = updateTreeHead()
+	options.FailIf(err, "Can't sign certificate log tree head")
+	go refreshTreeHead()
+
+	err = updateCRL()
+	options.FailIf(err, "Can't generate CRL")
+	go refreshCRL()
+	if manualMode {
+		go expirePending()
+	}
+	if crladdr := *options.String["crladdr"]; crladdr != "" {
+		fmt.Printf("Serving CRL at %s using HTTP\n", crladdr)
+		go func() {
+			err := http.ListenAndServe(crladdr, http.HandlerFunc(serveCRL))
+			options.FailIf(err, "can't serve CRL")
+		}()
+	}
+	if ocspaddr := *options.String["ocspaddr"]; ocspaddr != "" {
+		responder, err := newOCSPResponder()
+		options.FailIf(err, "Can't create OCSP responder")
+		fmt.Printf("Serving OCSP responses at %s using HTTP\n", ocspaddr)
+		go func() {
+			err := http.ListenAndServe(ocspaddr, responder)
+			options.FailIf(err, "can't serve OCSP responses")
+		}()
+	}
+
+	if acmeaddr := *options.String["acmeaddr"]; acmeaddr != "" {
+		if manualMode {
+			options.Fail(nil, "the ACME front-end requires automatic mode")
+		}
+		acmeurl := *options.String["acmeurl"]
+		if acmeurl == "" {
+			acmeurl = "http://" + acmeaddr + "/acme"
+		}
+		fmt.Printf("Serving ACME directory at %s/directory\n", acmeurl)
+		go func() {
+			err := http.ListenAndServe(acmeaddr, newACMEServer(acmeurl))
+			options.FailIf(err, "can't serve ACME")
+		}()
+	}
+
+	if docaddr := *options.String["docaddr"]; docaddr != "" {
+		fmt.Printf("Serving published documents at %s using HTTPS\n", docaddr)
+		go serveDocs(docaddr)
+	}
+
+	if adminaddr := *options.String["adminaddr"]; adminaddr != "" {
+		fmt.Printf("Serving admin console at %s using HTTPS\n", adminaddr)
+		go serveConsole(adminaddr, *options.String["adminroots"])
 	}
 
 	var prin auth.Prin
@@ -0,0 +1,55 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/x509"
+
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// parentPathLen returns the number of CA certificates that may follow this CA's
+// own certificate in a chain, or -1 if there is no limit.
+func parentPathLen() int {
+	cert := caKeys.Cert["default"]
+	if cert == nil || !cert.BasicConstraintsValid {
+		return -1
+	}
+	if cert.MaxPathLen > 0 || cert.MaxPathLenZero {
+		return cert.MaxPathLen
+	}
+	return -1
+}
+
+// constrainCA sets the basic constraints and name constraints for a subsidiary
+// CA certificate, according to this CA's own path length limit and the policy
+// rule, if any, that applies to the requester.
+func constrainCA(template *x509.Certificate, sub *policy.Subsidiary) {
+	template.BasicConstraintsValid = true
+	max := -1
+	if sub != nil {
+		max = sub.MaxPathLen
+		template.PermittedDNSDomains = sub.DNS
+		template.PermittedIPRanges = sub.IP
+		template.PermittedDNSDomainsCritical = len(sub.DNS) > 0 || len(sub.IP) > 0
+	}
+	if n := parentPathLen(); n > 0 && (max < 0 || max > n-1) {
+		max = n - 1
+	}
+	if max >= 0 {
+		template.MaxPathLen = max
+		template.MaxPathLenZero = max == 0
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/translog"
+)
+
+// Every issued certificate is appended to a Merkle tree log, and a signed tree
+// head is refreshed periodically. Auditors can fetch consistency proofs between
+// tree heads to check that the log is never rewritten.
+var tlog *translog.Log
+
+// The latest signed tree head is kept, along with the latest one signed for
+// each earlier size, so that consistency queries can be answered without
+// signing anything new.
+var sthLock = &sync.Mutex{}
+var sth *taoca.TreeHead
+var signedHeads = make(map[int64]*taoca.TreeHead)
+
+// openTransLog opens the log, adding any issued certificates missing from it,
+// e.g. those issued before the log existed or those that failed to be logged.
+func openTransLog(path string) error {
+	var err error
+	tlog, err = translog.Open(path)
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, r := range issued.Find(&issuance.Query{IssuedOnly: true}) {
+		if _, ok := tlog.Find(r.Cert); ok {
+			continue
+		}
+		if _, err := tlog.Append(r.Cert); err != nil {
+			return err
+		}
+		n++
+	}
+	if n > 0 {
+		fmt.Printf("Added %d missing certificates to log\n", n)
+	}
+	fmt.Printf("Certificate log contains %d entries\n", tlog.Size())
+	return nil
+}
+
+// signTreeHead signs a tree head for the first size entries of the log.
+func signTreeHead(size int64) (*taoca.TreeHead, error) {
+	root, err := tlog.Root(size)
+	if err != nil {
+		return nil, err
+	}
+	th := &taoca.TreeHead{
+		TreeSize:  proto.Int64(size),
+		Timestamp: proto.Int64(time.Now().UnixNano()),
+		RootHash:  root,
+	}
+	if err := taoca.SignTreeHead(caKeys.SigningKey, th); err != nil {
+		return nil, err
+	}
+	return th, nil
+}
+
+// updateTreeHead signs a fresh tree head for the entire log.
+func updateTreeHead() (*taoca.TreeHead, error) {
+	th, err := signTreeHead(tlog.Size())
+	if err != nil {
+		return nil, err
+	}
+	sthLock.Lock()
+	if sth == nil || th.GetTreeSize() >= sth.GetTreeSize() {
+		sth = th
+	}
+	signedHeads[th.GetTreeSize()] = th
+	sthLock.Unlock()
+	return th, nil
+}
+
+// refreshTreeHead periodically signs a fresh tree head.
+func refreshTreeHead() {
+	period, err := time.ParseDuration(*options.String["logperiod"])
+	options.FailIf(err, "bad -logperiod option")
+	for range time.Tick(period) {
+		if _, err := updateTreeHead(); err != nil {
+			fmt.Printf("error signing tree head: %s\n", err)
+		}
+	}
+}
+
+// logCertificate appends a certificate to the log and returns proof of its
+// inclusion under a freshly signed tree head.
+func logCertificate(der []byte) (*taoca.InclusionProof, error) {
+	index, err := tlog.Append(der)
+	if err != nil {
+		return nil, err
+	}
+	th, err := updateTreeHead()
+	if err != nil {
+		return nil, err
+	}
+	path, err := tlog.InclusionProof(index, th.GetTreeSize())
+	if err != nil {
+		return nil, err
+	}
+	return &taoca.InclusionProof{
+		LeafIndex: proto.Int64(index),
+		TreeHead:  th,
+		AuditPath: path,
+	}, nil
+}
+
+func doConsistency(conn *tao.Conn, req *taoca.Request) bool {
+	if req.Consistency == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "missing consistency query")
+		return false
+	}
+	first := req.Consistency.GetFirst()
+	second := req.Consistency.GetSecond()
+
+	// Only tree heads that have already been signed are served. Signing them
+	// on demand would give any peer a signing oracle, and a cheap way to keep
+	// the CA busy.
+	var th *taoca.TreeHead
+	sthLock.Lock()
+	if second == 0 {
+		th = sth
+		second = th.GetTreeSize()
+	} else {
+		th = signedHeads[second]
+	}
+	sthLock.Unlock()
+	if th == nil {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "no signed tree head of that size")
+		return false
+	}
+	if first < 0 || first > second {
+		doError(conn, nil, taoca.ResponseStatus_TAOCA_BAD_REQUEST, "invalid tree sizes")
+		return false
+	}
+	proof, err := tlog.ConsistencyProof(first, second)
+	if err != nil {
+		doError(conn, err, taoca.ResponseStatus_TAOCA_ERROR, "failed to generate consistency proof")
+		return false
+	}
+
+	status := taoca.ResponseStatus_TAOCA_OK
+	sendResponse(conn, &taoca.Response{
+		Status:           &status,
+		TreeHead:         th,
+		ConsistencyProof: proof,
+	})
+	return true
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// taoca_issuance queries the issuance database of a TaoCA server, answering
+// questions like "what did we issue to whom, and when".
+//
+// Examples:
+//   taoca_issuance /etc/tao/https_ca/issuance list
+//   taoca_issuance -cn 192.168.1.3 -since 720h /etc/tao/https_ca/issuance list
+//   taoca_issuance -denied /etc/tao/https_ca/issuance list
+//   taoca_issuance /etc/tao/https_ca/issuance show 1234567890
+//   taoca_issuance /etc/tao/https_ca/issuance pem 1234567890
+
+package main
+
+import (
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca/issuance"
+	"github.com/kevinawalsh/taoca/util/indent"
+	"github.com/kevinawalsh/taoca/util/x509txt"
+)
+
+var opts = []options.Option{
+	{"peer", "", "<prin>", "Select requests by principals containing this text", "all"},
+	{"key", "", "<prin>", "Select requests by subject keys containing this text", "all"},
+	{"ou", "", "<name>", "Select requests for this OrganizationalUnit", "all"},
+	{"cn", "", "<name>", "Select requests for this CommonName", "all"},
+	{"since", "", "<time>", "Select requests since this time or duration ago", "all"},
+	{"until", "", "<time>", "Select requests until this time or duration ago", "all"},
+	{"issued", false, "", "Select only issued certificates", "all"},
+	{"denied", false, "", "Select only denied requests", "all"},
+	{"revoked", false, "", "Select only revocations", "all"},
+}
+
+func init() {
+	options.Add(opts...)
+}
+
+// parseTime accepts RFC 3339 times, dates, or durations relative to now.
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("can't parse time: %s", s)
+	}
+	return time.Now().Add(-d), nil
+}
+
+func show(r *issuance.Record) {
+	fmt.Printf("Serial: %d\n", r.Serial)
+	fmt.Printf("Time: %s\n", r.Time.Format(time.RFC3339))
+	fmt.Printf("Status: %s\n", r.Status)
+	if r.Detail != "" {
+		fmt.Printf("Detail: %s\n", r.Detail)
+	}
+	fmt.Printf("Subject: %s\n", r.Subject)
+	if len(r.AltNames) > 0 {
+		fmt.Printf("Alternative Names: %s\n", strings.Join(r.AltNames, ", "))
+	}
+	fmt.Printf("Certificate Authority: %v\n", r.IsCA)
+	if r.Profile != "" {
+		fmt.Printf("Profile: %s\n", r.Profile)
+	}
+	fmt.Printf("Requesting Principal: %s\n", r.Peer)
+	fmt.Printf("Public Key Principal: %s\n", r.SubjectKey)
+	if r.Explanation != "" {
+		fmt.Printf("Policy Decision: %s\n", r.Explanation)
+	}
+	if !r.Issued() {
+		return
+	}
+	fmt.Printf("Not Before: %s\n", r.NotBefore.Format(time.RFC3339))
+	fmt.Printf("Not After: %s\n", r.NotAfter.Format(time.RFC3339))
+	fmt.Printf("CPS: %s\n", r.CPS)
+	fmt.Printf("User Notice: %s\n", r.UserNotice)
+	if r.Notice != "" {
+		fmt.Printf("Machine-readable Notice: %s\n", r.Notice)
+	}
+	if r.IsRevoked() {
+		fmt.Printf("Revoked: %s (reason %d)\n", r.Revoked.Format(time.RFC3339), r.Reason)
+	}
+	cert, err := r.Certificate()
+	options.FailIf(err, "can't parse certificate")
+	x509txt.Dump(indent.NewTextWriter(os.Stdout, 2), cert)
+}
+
+func main() {
+	options.Help = "Usage: %s [options] dbfile (list | show serial... | pem serial...)"
+	options.Parse()
+
+	args := options.Args()
+	if len(args) < 2 {
+		options.Usage("Missing database file or command")
+	}
+
+	db, err := issuance.Open(args[0])
+	options.FailIf(err, "can't open issuance database")
+
+	switch args[1] {
+	case "list":
+		q := &issuance.Query{
+			Peer:        *options.String["peer"],
+			SubjectKey:  *options.String["key"],
+			OU:          *options.String["ou"],
+			CN:          *options.String["cn"],
+			IssuedOnly:  *options.Bool["issued"],
+			DeniedOnly:  *options.Bool["denied"],
+			RevokedOnly: *options.Bool["revoked"],
+		}
+		q.Since, err = parseTime(*options.String["since"])
+		options.FailIf(err, "bad -since option")
+		q.Until, err = parseTime(*options.String["until"])
+		options.FailIf(err, "bad -until option")
+		records := db.Find(q)
+		fmt.Printf("# %d records\n", len(records))
+		for _, r := range records {
+			fmt.Printf("%s %-20s %19d ou=%q cn=%q %s\n",
+				r.Time.Format(time.RFC3339), r.Status, r.Serial,
+				r.OrganizationalUnit, r.CommonName, r.Peer)
+		}
+	case "show", "pem":
+		for _, arg := range args[2:] {
+			serial, err := strconv.ParseInt(arg, 10, 64)
+			options.FailIf(err, "bad serial number: %s", arg)
+			r := db.Lookup(serial)
+			if r == nil {
+				options.Fail(nil, "no certificate issued with serial %d", serial)
+			}
+			if args[1] == "pem" {
+				pem.Encode(os.Stdout, &pem.Block{Type: "CERTIFICATE", Bytes: r.Cert})
+			} else {
+				show(r)
+				fmt.Println()
+			}
+		}
+	default:
+		options.Usage("Unrecognized command: %s\n", args[1])
+	}
+}
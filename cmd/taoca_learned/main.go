@@ -0,0 +1,107 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// taoca_learned reviews the rules learned by a TaoCA server running with the
+// -learn option. Rules are numbered as listed. Promoted rules are appended to
+// the policy file, which the server reloads when it changes, and the hash of the
+// new policy is printed. Discarded rules are dropped by the server the next time
+// it reloads its policy, e.g. on SIGHUP.
+//
+// Examples:
+//   taoca_learned /etc/tao/https_ca list
+//   taoca_learned /etc/tao/https_ca promote 1 3
+//   taoca_learned /etc/tao/https_ca discard all
+
+package main
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// selectRules splits the learned rules into those selected by args, which are
+// rule numbers or "all", and the rest.
+func selectRules(learned []*policy.Learned, args []string) (selected, rest []*policy.Learned) {
+	chosen := make(map[int]bool)
+	for _, arg := range args {
+		if arg == "all" {
+			return learned, nil
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > len(learned) {
+			options.Fail(nil, "bad rule number: %s", arg)
+		}
+		chosen[n-1] = true
+	}
+	for i, l := range learned {
+		if chosen[i] {
+			selected = append(selected, l)
+		} else {
+			rest = append(rest, l)
+		}
+	}
+	return
+}
+
+func main() {
+	options.Help = "Usage: %s [options] keysdir (list | promote (n... | all) | discard (n... | all))"
+	options.Parse()
+
+	args := options.Args()
+	if len(args) < 2 {
+		options.Usage("Missing keys directory or command")
+	}
+	lpath := path.Join(args[0], "learned")
+	ppath := path.Join(args[0], "policy")
+
+	// Hold the lock until the remaining rules are saved, so that rules the
+	// server learns in the meantime are not lost.
+	unlock, err := policy.LockLearned(lpath)
+	options.FailIf(err, "can't lock learned rules")
+	defer unlock()
+
+	learned, err := policy.LoadLearned(lpath)
+	options.FailIf(err, "can't load learned rules")
+
+	switch args[1] {
+	case "list":
+		fmt.Printf("# %d learned rules\n", len(learned))
+		for i, l := range learned {
+			fmt.Printf("%3d %s ou=%q cn=%q %s\n    %s\n",
+				i+1, l.Time.Format(time.RFC3339), l.OU, l.CN, l.Principal, l.Rule)
+		}
+	case "promote", "discard":
+		if len(args) < 3 {
+			options.Usage("Missing rule numbers")
+		}
+		selected, rest := selectRules(learned, args[2:])
+		if args[1] == "promote" {
+			hash, err := policy.Promote(ppath, selected)
+			options.FailIf(err, "can't promote rules into policy")
+			fmt.Printf("Promoted %d rules into %s\n", len(selected), ppath)
+			fmt.Printf("New policy hash: %x\n", hash)
+		} else {
+			fmt.Printf("Discarded %d rules\n", len(selected))
+		}
+		err = policy.SaveLearned(lpath, rest)
+		options.FailIf(err, "can't save remaining learned rules")
+	default:
+		options.Usage("Unrecognized command: %s\n", args[1])
+	}
+}
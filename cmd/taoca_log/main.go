@@ -0,0 +1,125 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// taoca_log audits the log of certificates issued by a TaoCA server. It fetches
+// the CA's latest signed tree head, or a tree head for a given size, along with
+// a proof that the log at an earlier size is a prefix of it. Given the root
+// hash of an earlier tree head (the -root option), the proof is checked, and
+// given the CA certificate (the -cacert option), the signature is checked.
+
+package main
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/translog"
+)
+
+var opts = []options.Option{
+	{"ca", "", "<ip:port>", "Address of CA server, instead of using rendezvous", "all"},
+	{"keys", "", "<dir>", "Directory containing tao-sealed keys for authenticating", "all"},
+	{"cacert", "", "<file>", "PEM file with CA certificate, for checking tree head signatures", "all"},
+	{"root", "", "<hex>", "Root hash of the earlier tree, for checking the consistency proof", "all"},
+}
+
+func init() {
+	options.Add(opts...)
+}
+
+func main() {
+	options.Help = "Usage: %s [options] first [second]"
+	options.Parse()
+
+	args := options.Args()
+	if len(args) < 1 || len(args) > 2 {
+		options.Usage("Expecting one or two tree sizes")
+	}
+	first, err := strconv.ParseInt(args[0], 10, 64)
+	options.FailIf(err, "bad tree size: %s", args[0])
+	var second int64
+	if len(args) == 2 {
+		second, err = strconv.ParseInt(args[1], 10, 64)
+		options.FailIf(err, "bad tree size: %s", args[1])
+	}
+
+	if tao.Parent() == nil {
+		options.Fail(nil, "can't continue: no host Tao available")
+	}
+
+	var keys *tao.Keys
+	if kdir := *options.String["keys"]; kdir != "" {
+		keys = taoca.LoadKeys(kdir)
+	} else {
+		keys, err = tao.NewTemporaryTaoDelegatedKeys(tao.Signing, nil, tao.Parent())
+		options.FailIf(err, "can't create tao-delegated keys")
+	}
+
+	var server *taoca.Server
+	if addr := *options.String["ca"]; addr != "" {
+		host, port, err := net.SplitHostPort(addr)
+		options.FailIf(err, "bad address: %s", addr)
+		server = &taoca.Server{Host: host, Port: port}
+	} else {
+		server, err = taoca.GetDefaultServer()
+		options.FailIf(err, "can't locate CA server")
+	}
+
+	th, proof, err := server.Consistency(keys, first, second)
+	options.FailIf(err, "can't fetch consistency proof")
+
+	fmt.Printf("Tree Size: %d\n", th.GetTreeSize())
+	fmt.Printf("Timestamp: %s\n", time.Unix(0, th.GetTimestamp()).Format(time.RFC3339))
+	fmt.Printf("Root Hash: %s\n", hex.EncodeToString(th.GetRootHash()))
+	fmt.Printf("Consistency Proof from size %d:\n", first)
+	for _, h := range proof {
+		fmt.Printf("  %s\n", hex.EncodeToString(h))
+	}
+
+	if path := *options.String["cacert"]; path != "" {
+		pemData, err := ioutil.ReadFile(path)
+		options.FailIf(err, "can't read CA certificate")
+		block, _ := pem.Decode(pemData)
+		if block == nil {
+			options.Fail(nil, "no PEM data in %s", path)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		options.FailIf(err, "can't parse CA certificate")
+		v, err := tao.FromX509(cert)
+		options.FailIf(err, "can't use CA key")
+		ok, err := taoca.VerifyTreeHead(v, th)
+		if !ok {
+			options.Fail(err, "tree head signature does not verify")
+		}
+		fmt.Printf("Tree head signature verified\n")
+	}
+
+	if r := *options.String["root"]; r != "" {
+		root, err := hex.DecodeString(r)
+		options.FailIf(err, "bad -root option")
+		if !translog.VerifyConsistency(first, th.GetTreeSize(), root, th.GetRootHash(), proof) {
+			options.Fail(nil, "log is NOT consistent with the earlier tree")
+		}
+		fmt.Printf("Log is consistent with the earlier tree\n")
+	}
+}
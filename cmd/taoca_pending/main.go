@@ -0,0 +1,108 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// taoca_pending lists, approves, or denies certificate requests queued for
+// manual approval by a TaoCA server running in manual mode. It authenticates
+// using the tao-sealed keys of a service (the -keys option), or otherwise using
+// fresh keys delegated by the host Tao. Either way, the authenticated principal
+// must be one of the CA's admin principals.
+
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+)
+
+var opts = []options.Option{
+	{"ca", "", "<ip:port>", "Address of CA server, instead of using rendezvous", "all"},
+	{"keys", "", "<dir>", "Directory containing tao-sealed keys for authenticating", "all"},
+}
+
+func init() {
+	options.Add(opts...)
+}
+
+func main() {
+	options.Help = "Usage: %s [options] (list | approve id... | deny id...)"
+	options.Parse()
+
+	args := options.Args()
+	if len(args) == 0 {
+		options.Usage("Missing command")
+	}
+	cmd, ids := args[0], args[1:]
+	switch cmd {
+	case "list":
+		if len(ids) != 0 {
+			options.Usage("Unexpected arguments for list")
+		}
+	case "approve", "deny":
+		if len(ids) == 0 {
+			options.Usage("Missing request ID")
+		}
+	default:
+		options.Usage("Unrecognized command: %s", cmd)
+	}
+
+	if tao.Parent() == nil {
+		options.Fail(nil, "can't continue: no host Tao available")
+	}
+
+	var keys *tao.Keys
+	var err error
+	if kdir := *options.String["keys"]; kdir != "" {
+		keys = taoca.LoadKeys(kdir)
+	} else {
+		keys, err = tao.NewTemporaryTaoDelegatedKeys(tao.Signing, nil, tao.Parent())
+		options.FailIf(err, "can't create tao-delegated keys")
+	}
+
+	var server *taoca.Server
+	if addr := *options.String["ca"]; addr != "" {
+		host, port, err := net.SplitHostPort(addr)
+		options.FailIf(err, "bad address: %s", addr)
+		server = &taoca.Server{Host: host, Port: port}
+	} else {
+		server, err = taoca.GetDefaultServer()
+		options.FailIf(err, "can't locate CA server")
+	}
+
+	if cmd == "list" {
+		pending, err := server.ListPending(keys)
+		options.FailIf(err, "can't list pending requests")
+		if len(pending) == 0 {
+			fmt.Println("No pending requests")
+		}
+		for _, p := range pending {
+			fmt.Printf("Request %s:%s\n", p.GetRequestId(), p.GetDetails())
+		}
+		return
+	}
+
+	approve := cmd == "approve"
+	for _, id := range ids {
+		err := server.Decide(keys, id, approve)
+		options.FailIf(err, "can't %s request %s", cmd, id)
+		if approve {
+			fmt.Printf("Approved request %s\n", id)
+		} else {
+			fmt.Printf("Denied request %s\n", id)
+		}
+	}
+}
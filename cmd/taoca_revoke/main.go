@@ -0,0 +1,84 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// taoca_revoke asks a TaoCA server to revoke certificates. It authenticates
+// using the tao-sealed keys of a service (the -keys option), or otherwise using
+// fresh keys delegated by the host Tao. Either way, the authenticated principal
+// must have requested the certificates, or be one of the CA's admin principals.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+)
+
+var opts = []options.Option{
+	{"ca", "", "<ip:port>", "Address of CA server, instead of using rendezvous", "all"},
+	{"keys", "", "<dir>", "Directory containing tao-sealed keys for authenticating", "all"},
+	{"reason", "0", "<code>", "RFC 5280 CRLReason code, e.g. 1 for keyCompromise", "all"},
+}
+
+func init() {
+	options.Add(opts...)
+}
+
+func main() {
+	options.Help = "Usage: %s [options] serial..."
+	options.Parse()
+
+	args := options.Args()
+	if len(args) == 0 {
+		options.Usage("Missing serial number")
+	}
+
+	if tao.Parent() == nil {
+		options.Fail(nil, "can't continue: no host Tao available")
+	}
+
+	var keys *tao.Keys
+	var err error
+	if kdir := *options.String["keys"]; kdir != "" {
+		keys = taoca.LoadKeys(kdir)
+	} else {
+		keys, err = tao.NewTemporaryTaoDelegatedKeys(tao.Signing, nil, tao.Parent())
+		options.FailIf(err, "can't create tao-delegated keys")
+	}
+
+	var server *taoca.Server
+	if addr := *options.String["ca"]; addr != "" {
+		host, port, err := net.SplitHostPort(addr)
+		options.FailIf(err, "bad address: %s", addr)
+		server = &taoca.Server{Host: host, Port: port}
+	} else {
+		server, err = taoca.GetDefaultServer()
+		options.FailIf(err, "can't locate CA server")
+	}
+
+	reason, err := strconv.Atoi(*options.String["reason"])
+	options.FailIf(err, "bad -reason option")
+
+	for _, arg := range args {
+		serial, err := strconv.ParseInt(arg, 10, 64)
+		options.FailIf(err, "bad serial number: %s", arg)
+		err = server.Revoke(keys, serial, reason)
+		options.FailIf(err, "can't revoke certificate %d", serial)
+		fmt.Printf("Revoked certificate %d\n", serial)
+	}
+}
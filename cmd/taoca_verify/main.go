@@ -0,0 +1,232 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// taoca_verify checks a certificate issued by a TaoCA server. It takes a file
+// holding a certificate or chain (PEM or DER), or the address of a TLS server,
+// and verifies the chain against a TaoCA root certificate (the -root option).
+// It then fetches the certification practices statement and user notice linked
+// from the certificate, checks that the hash of each matches its URL, and
+// prints them, along with the issuing CA's principal. If the certificate links
+// to a machine-readable user notice, that is checked too, and the requesting
+// principal and the policy rules that authorized the request are printed.
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/options"
+	"github.com/kevinawalsh/taoca"
+	"github.com/kevinawalsh/taoca/util/x509txt"
+)
+
+var opts = []options.Option{
+	{"root", "", "<file>", "PEM or DER file with the TaoCA root certificate", "all"},
+	{"quiet", false, "", "Don't print the policy documents", "all"},
+}
+
+func init() {
+	options.Add(opts...)
+}
+
+// readCerts parses a file holding one or more PEM certificates, or a single
+// DER certificate.
+func readCerts(filename string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) > 0 {
+		return certs, nil
+	}
+	return x509.ParseCertificates(data)
+}
+
+// fetchCerts gets the certificate chain presented by a TLS server. The chain
+// is verified later, against the TaoCA root rather than the system roots.
+func fetchCerts(addr string) ([]*x509.Certificate, error) {
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates, nil
+}
+
+// fetchDoc downloads a content-addressed policy document and checks that its
+// hash matches its URL.
+func fetchDoc(client *http.Client, loc string) ([]byte, error) {
+	u, err := url.Parse(loc)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", loc, resp.Status)
+	}
+	doc, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	name := path.Base(u.Path)
+	hash := fmt.Sprintf("%x", sha256.Sum256(doc))
+	if hash+path.Ext(name) != name {
+		return nil, fmt.Errorf("%s: hash %s does not match URL", loc, hash)
+	}
+	return doc, nil
+}
+
+func main() {
+	options.Help = "Usage: %s [options] (certfile | host:port)"
+	options.Parse()
+
+	args := options.Args()
+	if len(args) != 1 {
+		options.Usage("Expecting a certificate file or TLS server address")
+	}
+	if *options.String["root"] == "" {
+		options.Usage("Option -root is required")
+	}
+
+	roots, err := readCerts(*options.String["root"])
+	options.FailIf(err, "can't read root certificate")
+	if len(roots) == 0 {
+		options.Fail(nil, "no certificates in %s", *options.String["root"])
+	}
+	rootPool := x509.NewCertPool()
+	for _, cert := range roots {
+		rootPool.AddCert(cert)
+	}
+
+	var certs []*x509.Certificate
+	if _, err := os.Stat(args[0]); err == nil {
+		certs, err = readCerts(args[0])
+		options.FailIf(err, "can't read certificates")
+	} else {
+		certs, err = fetchCerts(args[0])
+		options.FailIf(err, "can't get certificates from %s", args[0])
+	}
+	if len(certs) == 0 {
+		options.Fail(nil, "no certificates found")
+	}
+
+	leaf := certs[0]
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	chains, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	options.FailIf(err, "certificate does not verify")
+	fmt.Printf("Certificate chain verifies:\n")
+	for i, cert := range chains[0] {
+		fmt.Printf("  %d: %s\n", i, x509txt.RDNString(cert.Subject))
+	}
+
+	if len(chains[0]) > 1 {
+		issuer := chains[0][1]
+		if v, err := tao.FromX509(issuer); err == nil {
+			fmt.Printf("Issuing CA principal: %s\n", v.ToPrincipal())
+		} else {
+			fmt.Printf("Issuing CA principal: unknown (%s)\n", err)
+		}
+	}
+
+	var cps, unotice, notice string
+	for _, e := range leaf.Extensions {
+		if c, u, err := x509txt.ExtractCertificationPolicy(e); err == nil {
+			cps, unotice = c, u
+		}
+		if n, err := x509txt.ExtractNotice(e); err == nil {
+			notice = n
+		}
+	}
+	if cps == "" {
+		options.Fail(nil, "certificate has no TaoCA certification policy")
+	}
+
+	// The documents may be served by the CA itself, so trust its root too.
+	docPool, err := x509.SystemCertPool()
+	if err != nil {
+		docPool = x509.NewCertPool()
+	}
+	for _, cert := range roots {
+		docPool.AddCert(cert)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: docPool}}}
+
+	cpsDoc, err := fetchDoc(client, cps)
+	options.FailIf(err, "can't verify certification practices statement")
+	fmt.Printf("Certification practices statement verifies: %s\n", cps)
+	unoticeDoc, err := fetchDoc(client, unotice)
+	options.FailIf(err, "can't verify user notice")
+	fmt.Printf("User notice verifies: %s\n", unotice)
+	if notice != "" {
+		noticeDoc, err := fetchDoc(client, notice)
+		options.FailIf(err, "can't verify machine-readable user notice")
+		var n taoca.Notice
+		err = json.Unmarshal(noticeDoc, &n)
+		options.FailIf(err, "can't parse machine-readable user notice")
+		if n.Serial != leaf.SerialNumber.Int64() || n.CPS != cps || n.UserNotice != unotice {
+			options.Fail(nil, "machine-readable user notice does not match certificate")
+		}
+		fmt.Printf("Machine-readable user notice verifies: %s\n", notice)
+		fmt.Printf("Requesting principal: %s\n", n.Principal)
+		if n.PolicyHash != "" {
+			fmt.Printf("Policy hash: %s\n", n.PolicyHash)
+		}
+		if n.Explanation != nil {
+			fmt.Printf("Policy decision: %s\n", n.Explanation)
+		}
+	}
+
+	if !*options.Bool["quiet"] {
+		fmt.Printf("\n== Certification Practices Statement ==\n%s\n", cpsDoc)
+		fmt.Printf("\n== User Notice ==\n%s\n", unoticeDoc)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResponseError is an error response from a certificate authority server, e.g.
+// a denial. The server was reached, so the request should not be retried
+// elsewhere.
+type ResponseError struct {
+	// Status is the status of the response.
+	Status ResponseStatus
+
+	// Detail is the error detail given by the server, if any.
+	Detail string
+
+	// RetryAfter, if not zero, is how long the server asked the client to wait
+	// before trying again, e.g. when a rate limit is exceeded.
+	RetryAfter time.Duration
+}
+
+func (e *ResponseError) Error() string {
+	detail := e.Detail
+	if detail == "" {
+		detail = "unknown error"
+	}
+	if e.RetryAfter != 0 {
+		detail += fmt.Sprintf(" (retry after %ds)", int64(e.RetryAfter/time.Second))
+	}
+	return fmt.Sprintf("%s: %s", e.Status, detail)
+}
+
+// Denied returns true if the server denied the request.
+func (e *ResponseError) Denied() bool {
+	return e.Status == ResponseStatus_TAOCA_REQUEST_DENIED
+}
+
+// UnreachableError is returned when a certificate authority server can't be
+// reached, or the connection fails before a response arrives. These failures
+// are usually transient.
+type UnreachableError struct {
+	// Addr is the address of the server, or of each server tried.
+	Addr string
+
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("can't reach certificate authority server %s: %s", e.Addr, e.Err)
+}
+
+// Temporary returns true, since the server might be reached if tried again.
+func (e *UnreachableError) Temporary() bool {
+	return true
+}
+
+// Unwrap returns the underlying error.
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// IsDenied returns true if err is a denial from a certificate authority server.
+func IsDenied(err error) bool {
+	e, ok := err.(*ResponseError)
+	return ok && e.Denied()
+}
+
+// IsUnreachable returns true if err shows that no certificate authority server
+// could be reached.
+func IsUnreachable(err error) bool {
+	_, ok := err.(*UnreachableError)
+	return ok
+}
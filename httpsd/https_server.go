@@ -102,7 +102,7 @@ func main() {
 	http.Handle("/prin/", https.ManifestHandler{"/prin/", self.String()})
 	http.Handle("/", http.FileServer(https.LoggingFilesystem{http.Dir(docs)}))
 	fmt.Printf("Listening at %s using HTTPS\n", addr)
-	err = tao.ListenAndServeTLS(addr, keys)
+	err = taoca.ListenAndServeTLS(addr, keys)
 	options.FailIf(err, "can't listen and serve")
 
 	fmt.Println("Server Done")
@@ -0,0 +1,372 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package issuance provides a durable record of the certificates issued, and
+// the requests denied, by a TaoCA server.
+package issuance
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Note: Records are stored one per line, as json, and the file is only ever
+// appended to. This keeps the file human-readable and makes a partially
+// written final line the worst outcome of a crash. Such a line is dropped when
+// the database is next opened.
+
+// StatusRevoked is the status of records that revoke a previously issued
+// certificate.
+const StatusRevoked = "REVOKED"
+
+// Record details a single certificate issuance or denial, or the revocation of
+// a previously issued certificate.
+type Record struct {
+	// Serial is the serial number of the certificate.
+	Serial int64 `json:"serial"`
+
+	// Time is when the request was decided.
+	Time time.Time `json:"time"`
+
+	// Status is the response status sent to the requester, e.g. "TAOCA_OK".
+	Status string `json:"status"`
+
+	// Detail explains a denial.
+	Detail string `json:"detail,omitempty"`
+
+	// Subject is the requested x509 subject name, in RDN notation.
+	Subject            string `json:"subject"`
+	OrganizationalUnit string `json:"ou"`
+	CommonName         string `json:"cn"`
+
+	// AltNames are the requested subject alternative names, each prefixed by
+	// its type, e.g. "DNS:example.com".
+	AltNames []string `json:"alt_names,omitempty"`
+
+	// Peer is the requesting Tao principal, or "anonymous".
+	Peer string `json:"peer"`
+
+	// SubjectKey is the principal name of the subject's public key.
+	SubjectKey string `json:"subject_key,omitempty"`
+
+	// IsCA is true for requests for a certificate authority certificate.
+	IsCA bool `json:"is_ca"`
+
+	// Profile is the requested certificate profile, if any.
+	Profile string `json:"profile,omitempty"`
+
+	// NotBefore and NotAfter give the validity window of an issued certificate.
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+
+	// CPS and UserNotice are the urls of the published policy documents.
+	CPS        string `json:"cps,omitempty"`
+	UserNotice string `json:"unotice,omitempty"`
+
+	// Notice is the url of the published machine-readable user notice.
+	Notice string `json:"notice,omitempty"`
+
+	// Explanation describes the policy decision for the request: the rules
+	// that authorized it, or the closest rules for a denial.
+	Explanation string `json:"explanation,omitempty"`
+
+	// Cert is the DER encoded certificate, if one was issued.
+	Cert []byte `json:"cert,omitempty"`
+
+	// Reason is the RFC 5280 CRLReason code for a revocation.
+	Reason int `json:"reason,omitempty"`
+
+	// Revoked is when an issued certificate was revoked. This is not stored
+	// with the issuance record, but is filled in from the revocation record.
+	Revoked time.Time `json:"-"`
+}
+
+// Issued returns true if the record describes an issued certificate.
+func (r *Record) Issued() bool {
+	return len(r.Cert) > 0
+}
+
+// Revocation returns true if the record describes a revocation.
+func (r *Record) Revocation() bool {
+	return r.Status == StatusRevoked
+}
+
+// IsRevoked returns true if the record describes an issued certificate that
+// has since been revoked.
+func (r *Record) IsRevoked() bool {
+	return !r.Revoked.IsZero()
+}
+
+// Certificate parses the issued certificate.
+func (r *Record) Certificate() (*x509.Certificate, error) {
+	if !r.Issued() {
+		return nil, fmt.Errorf("no certificate was issued for serial %d", r.Serial)
+	}
+	return x509.ParseCertificate(r.Cert)
+}
+
+// DB is an append-only database of issuance records, backed by a file. It is
+// safe for concurrent use. Records are handed out as copies, since the record of
+// an issued certificate is updated in place when the certificate is revoked.
+type DB struct {
+	Path    string
+	lock    sync.RWMutex
+	records []*Record
+	serials map[int64]*Record
+	watch   []func(r *Record)
+}
+
+// Open loads the database stored in a file, creating the file if necessary. A
+// final record that can't be parsed, from a crash in the middle of an append,
+// is dropped and truncated from the file. Any other unparseable record is an
+// error.
+func Open(path string) (*DB, error) {
+	db := &DB{Path: path, serials: make(map[int64]*Record)}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	n := 0
+	for off := 0; off < len(b); {
+		n++
+		end := bytes.IndexByte(b[off:], '\n')
+		last := end < 0
+		if last {
+			end = len(b)
+		} else {
+			end += off
+			last = len(bytes.TrimSpace(b[end:])) == 0
+		}
+		line := bytes.TrimSpace(b[off:end])
+		if len(line) == 0 {
+			off = end + 1
+			continue
+		}
+		r := new(Record)
+		if err := json.Unmarshal(line, r); err != nil {
+			if !last {
+				return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+			}
+			fmt.Fprintf(os.Stderr, "issuance: dropping partial record at %s:%d: %s\n", path, n, err)
+			if err := f.Truncate(int64(off)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		if end == len(b) {
+			// The record is whole, but its newline is missing, so add one
+			// before anything more is appended.
+			if _, err := f.WriteAt([]byte{'\n'}, int64(end)); err != nil {
+				return nil, err
+			}
+		}
+		db.add(r)
+		off = end + 1
+	}
+	return db, nil
+}
+
+// add indexes a record. Callers must hold db.lock for writing, unless the
+// database is still being opened.
+func (db *DB) add(r *Record) {
+	db.records = append(db.records, r)
+	if r.Issued() {
+		db.serials[r.Serial] = r
+	} else if c, ok := db.serials[r.Serial]; ok && r.Revocation() && !c.IsRevoked() {
+		c.Revoked = r.Time
+		c.Reason = r.Reason
+	}
+	for _, f := range db.watch {
+		c := *r
+		f(&c)
+	}
+}
+
+// Watch calls f with a copy of each record in the database, in the order they
+// were added, then with a copy of each record appended from now on. Calls to f
+// are made while holding the database lock, so f must not use the database.
+func (db *DB) Watch(f func(r *Record)) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for _, r := range db.records {
+		c := *r
+		f(&c)
+	}
+	db.watch = append(db.watch, f)
+}
+
+// ErrAlreadyRevoked is returned when revoking a certificate that has already
+// been revoked.
+var ErrAlreadyRevoked = errors.New("certificate is already revoked")
+
+// Append durably adds a record to the database. The database keeps its own copy
+// of the record.
+func (db *DB) Append(r *Record) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.append(r)
+}
+
+// append durably adds a record to the database. Callers must hold db.lock for
+// writing.
+func (db *DB) append(r *Record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	f, err := os.OpenFile(db.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(line); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	c := *r
+	db.add(&c)
+	return nil
+}
+
+// Lookup returns a copy of the record of the certificate issued with the given
+// serial number, or nil if there is no such certificate.
+func (db *DB) Lookup(serial int64) *Record {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	r, ok := db.serials[serial]
+	if !ok {
+		return nil
+	}
+	c := *r
+	return &c
+}
+
+// Revoke durably records the revocation of a previously issued certificate. If
+// the certificate has already been revoked, ErrAlreadyRevoked is returned and
+// nothing is recorded.
+func (db *DB) Revoke(serial int64, reason int, peer string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	r, ok := db.serials[serial]
+	if !ok {
+		return fmt.Errorf("no certificate issued with serial %d", serial)
+	}
+	if r.IsRevoked() {
+		return ErrAlreadyRevoked
+	}
+	return db.append(&Record{
+		Serial: serial,
+		Time:   time.Now(),
+		Status: StatusRevoked,
+		Peer:   peer,
+		Reason: reason,
+	})
+}
+
+// Revoked returns copies of the records of all revoked certificates.
+func (db *DB) Revoked() []*Record {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	var revoked []*Record
+	for _, r := range db.records {
+		if r.Issued() && r.IsRevoked() {
+			c := *r
+			revoked = append(revoked, &c)
+		}
+	}
+	return revoked
+}
+
+// Records returns copies of all records in the database, in the order they
+// were added.
+func (db *DB) Records() []*Record {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	records := make([]*Record, len(db.records))
+	for i, r := range db.records {
+		c := *r
+		records[i] = &c
+	}
+	return records
+}
+
+// Query selects records from a database. Zero-valued fields match all records.
+type Query struct {
+	Peer        string
+	OU, CN      string
+	Since       time.Time
+	Until       time.Time
+	IssuedOnly  bool
+	DeniedOnly  bool
+	RevokedOnly bool
+	SubjectKey  string
+}
+
+// Match returns true if the record is selected by the query.
+func (q *Query) Match(r *Record) bool {
+	switch {
+	case q.Peer != "" && !strings.Contains(r.Peer, q.Peer):
+		return false
+	case q.OU != "" && r.OrganizationalUnit != q.OU:
+		return false
+	case q.CN != "" && r.CommonName != q.CN:
+		return false
+	case !q.Since.IsZero() && r.Time.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && r.Time.After(q.Until):
+		return false
+	case q.IssuedOnly && !r.Issued():
+		return false
+	case q.DeniedOnly && (r.Issued() || r.Revocation()):
+		return false
+	case q.RevokedOnly && !r.Revocation():
+		return false
+	case q.SubjectKey != "" && !strings.Contains(r.SubjectKey, q.SubjectKey):
+		return false
+	}
+	return true
+}
+
+// Find returns copies of all records selected by a query, in the order they
+// were added.
+func (db *DB) Find(q *Query) []*Record {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	var matches []*Record
+	for _, r := range db.records {
+		if q.Match(r) {
+			c := *r
+			matches = append(matches, &c)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package issuance
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+func TestAppendAndReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuance_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "issuance")
+
+	db, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	records := []*Record{
+		{Serial: 1, Time: now, Status: "TAOCA_OK", CommonName: "a", Peer: "alice", Cert: []byte{1}},
+		{Serial: 2, Time: now, Status: "TAOCA_REQUEST_DENIED", CommonName: "b", Peer: "bob"},
+		{Serial: 3, Time: now.Add(time.Hour), Status: "TAOCA_OK", CommonName: "b", Peer: "alice", Cert: []byte{3}},
+	}
+	for _, r := range records {
+		if err := db.Append(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err = Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(db.Records()); n != 3 {
+		t.Fatalf("expected 3 records, found %d", n)
+	}
+	if r := db.Lookup(3); r == nil || r.CommonName != "b" {
+		t.Fatalf("lookup of serial 3 failed: %v", r)
+	}
+	if r := db.Lookup(2); r != nil {
+		t.Fatalf("lookup of denied serial 2 should fail: %v", r)
+	}
+	if n := len(db.Find(&Query{Peer: "alice"})); n != 2 {
+		t.Fatalf("expected 2 records for alice, found %d", n)
+	}
+	if n := len(db.Find(&Query{CN: "b", IssuedOnly: true})); n != 1 {
+		t.Fatalf("expected 1 issued record for b, found %d", n)
+	}
+	if n := len(db.Find(&Query{Since: now.Add(time.Minute)})); n != 1 {
+		t.Fatalf("expected 1 recent record, found %d", n)
+	}
+
+	if err := db.Revoke(2, 1, "carol"); err == nil {
+		t.Fatal("revocation of denied serial 2 should fail")
+	}
+	if err := db.Revoke(3, 1, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := db.Lookup(3); r == nil || !r.IsRevoked() || r.Reason != 1 {
+		t.Fatalf("serial 3 should be revoked: %v", r)
+	}
+	if n := len(db.Revoked()); n != 1 {
+		t.Fatalf("expected 1 revoked certificate, found %d", n)
+	}
+	if n := len(db.Find(&Query{DeniedOnly: true})); n != 1 {
+		t.Fatalf("expected 1 denied record, found %d", n)
+	}
+}
+
+func TestTornFinalRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuance_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "issuance")
+
+	db, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Append(&Record{Serial: 1, Time: time.Now(), Status: "TAOCA_OK", Cert: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reopen, after simulating a crash in the middle of an append.
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(`{"serial":2,"time":"20`))
+	f.Close()
+	db, err = Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(db.Records()); n != 1 {
+		t.Fatalf("expected 1 record, found %d", n)
+	}
+
+	// The next record must not be appended to the partial line.
+	if err := db.Append(&Record{Serial: 3, Time: time.Now(), Status: "TAOCA_OK", Cert: []byte{3}}); err != nil {
+		t.Fatal(err)
+	}
+	db, err = Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(db.Records()); n != 2 || db.Lookup(3) == nil {
+		t.Fatalf("expected records for serials 1 and 3, found %d", n)
+	}
+
+	// Corruption before the final record is an error.
+	f, err = os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("garbage\n"))
+	f.Close()
+	if err := db.Append(&Record{Serial: 4, Time: time.Now(), Status: "TAOCA_OK", Cert: []byte{4}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(p); err == nil {
+		t.Fatal("open should fail for a corrupt record before the last")
+	}
+}
+
+func TestConcurrentRevoke(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuance_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := Open(path.Join(dir, "issuance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Append(&Record{Serial: 1, Time: time.Now(), Status: "TAOCA_OK", Cert: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	r := db.Lookup(1)
+
+	const n = 10
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		go func() { errs <- db.Revoke(1, 1, "carol") }()
+	}
+	revoked := 0
+	for i := 0; i < n; i++ {
+		switch err := <-errs; err {
+		case nil:
+			revoked++
+		case ErrAlreadyRevoked:
+		default:
+			t.Fatal(err)
+		}
+	}
+	if revoked != 1 {
+		t.Fatalf("expected 1 revocation, found %d", revoked)
+	}
+	if n := len(db.Find(&Query{RevokedOnly: true})); n != 1 {
+		t.Fatalf("expected 1 revocation record, found %d", n)
+	}
+	if r.IsRevoked() {
+		t.Fatal("record returned by lookup should not change")
+	}
+	if !db.Lookup(1).IsRevoked() {
+		t.Fatal("serial 1 should be revoked")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "issuance_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := Open(path.Join(dir, "issuance"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Append(&Record{Serial: 1, Time: time.Now(), Status: "TAOCA_OK", Cert: []byte{1}}); err != nil {
+		t.Fatal(err)
+	}
+	var seen []*Record
+	db.Watch(func(r *Record) { seen = append(seen, r) })
+	if len(seen) != 1 || seen[0].Serial != 1 {
+		t.Fatalf("expected existing record, saw %v", seen)
+	}
+	if err := db.Append(&Record{Serial: 2, Time: time.Now(), Status: "TAOCA_OK", Cert: []byte{2}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Revoke(1, 1, "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 3 || seen[1].Serial != 2 || !seen[2].Revocation() || seen[2].Serial != 1 {
+		t.Fatalf("expected appended records, saw %v", seen)
+	}
+	if seen[0].IsRevoked() {
+		t.Fatal("record passed to watcher should not change")
+	}
+}
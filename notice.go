@@ -0,0 +1,54 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// Notice is a machine-readable user notice, published as JSON alongside the
+// human-readable one. It records the circumstances under which a certificate
+// was issued, so relying parties can evaluate them automatically. It is linked
+// from the certificate policies extension, alongside the certification
+// practices statement. See NewCertficationPolicyWithNotice. The JSON field names
+// are part of the published format: fields may be added, but not renamed.
+type Notice struct {
+	// Serial is the serial number of the issued certificate.
+	Serial int64 `json:"serial"`
+
+	// Principal is the requesting Tao principal, or a description of the
+	// requester if it was not a single Tao principal.
+	Principal string `json:"principal"`
+
+	// Manifest is the decoded form of Principal, from tao.DeriveManifest.
+	Manifest tao.Manifest `json:"manifest,omitempty"`
+
+	// PolicyHash is the hex SHA-256 hash of the certificate-granting policy in
+	// effect, if the request was approved automatically.
+	PolicyHash string `json:"policy_hash,omitempty"`
+
+	// Explanation gives the policy rules that authorized the request.
+	Explanation *policy.Explanation `json:"explanation,omitempty"`
+
+	// Time is when the request was received.
+	Time time.Time `json:"time"`
+
+	// CPS and UserNotice are the urls of the human-readable policy documents.
+	CPS        string `json:"cps,omitempty"`
+	UserNotice string `json:"unotice,omitempty"`
+}
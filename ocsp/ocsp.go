@@ -0,0 +1,442 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package ocsp implements a minimal RFC 6960 OCSP responder, and just enough of
+// an OCSP client to check the responses.
+package ocsp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// The asn1 structures below follow RFC 6960, section 4. Only the parts needed
+// for a responder that handles unsigned, nonce-less requests are included.
+
+type certID struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+type ocspRequest struct {
+	TBSRequest tbsRequest
+}
+
+type tbsRequest struct {
+	Version       int              `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName pkix.RDNSequence `asn1:"explicit,tag:1,optional"`
+	RequestList   []request
+}
+
+type request struct {
+	Cert certID
+}
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version     int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID asn1.RawValue
+	ProducedAt  time.Time `asn1:"generalized"`
+	Responses   []singleResponse
+}
+
+type singleResponse struct {
+	CertID     certID
+	Good       asn1.Flag   `asn1:"tag:0,optional"`
+	Revoked    revokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag   `asn1:"tag:2,optional"`
+	ThisUpdate time.Time   `asn1:"generalized"`
+	NextUpdate time.Time   `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+type revokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+var (
+	// iso(1) identified-organization(3) dod(6) internet(1) security(5)
+	//   mechanisms(5) pkix(7) ad(48) id-pkix-ocsp(1) id-pkix-ocsp-basic(1)
+	idPKIXOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+
+	// iso(1) identified-organization(3) dod(6) internet(1) security(5)
+	//   mechanisms(5) pkix(7) ad(48) id-pkix-ocsp(1) id-pkix-ocsp-nocheck(5)
+	idPKIXOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+
+	// iso(1) identified-organization(3) oiw(14) secsig(3) algorithms(2) sha1(26)
+	idSHA1 = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+
+	// joint-iso-itu-t(2) country(16) us(840) organization(1) gov(101) csor(3)
+	//   nistalgorithm(4) hashalgs(2) sha256(1)
+	idSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+
+	// iso(1) member-body(2) us(840) ansi-x962(10045) signatures(4)
+	//   ecdsa-with-SHA2(3) ecdsa-with-SHA256(2)
+	idECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+
+	// iso(1) member-body(2) us(840) rsadsi(113549) pkcs(1) pkcs-1(1)
+	//   sha256WithRSAEncryption(11)
+	idSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+)
+
+// Status is the revocation status of a certificate.
+type Status int
+
+const (
+	Good Status = iota
+	Revoked
+	Unknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case Good:
+		return "good"
+	case Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+// Response status codes, from RFC 6960.
+const (
+	successful       = 0
+	malformedRequest = 1
+	internalError    = 2
+)
+
+// CertStatus details the revocation status of a certificate.
+type CertStatus struct {
+	Status         Status
+	RevocationTime time.Time
+	Reason         int
+}
+
+// NoCheckExtension returns the id-pkix-ocsp-nocheck extension, which should be
+// included in a delegated OCSP signing certificate.
+func NoCheckExtension() pkix.Extension {
+	null, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagNull})
+	return pkix.Extension{Id: idPKIXOCSPNoCheck, Value: null}
+}
+
+// Responder answers OCSP requests for certificates signed by a single issuer.
+type Responder struct {
+	// Issuer is the certificate of the CA whose certificates are checked.
+	Issuer *x509.Certificate
+
+	// Signer signs responses. It may be the issuer's key or a delegated key.
+	Signer crypto.Signer
+
+	// SignerCert is the certificate for Signer. If it differs from Issuer, it
+	// must be issued by Issuer with the OCSP signing extended key usage.
+	SignerCert *x509.Certificate
+
+	// Lookup returns the status for a serial number.
+	Lookup func(serial *big.Int) CertStatus
+
+	// Period is how long responses are valid.
+	Period time.Duration
+}
+
+// subjectKeyHash computes the hash of the subjectPublicKey of a certificate, as
+// used in the issuerKeyHash and byKey fields of OCSP messages.
+func subjectKeyHash(cert *x509.Certificate, h crypto.Hash) ([]byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(cert.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return nil, err
+	}
+	return hash(h, spki.PublicKey.RightAlign()), nil
+}
+
+func hash(h crypto.Hash, data []byte) []byte {
+	switch h {
+	case crypto.SHA1:
+		d := sha1.Sum(data)
+		return d[:]
+	default:
+		d := sha256.Sum256(data)
+		return d[:]
+	}
+}
+
+// matchesIssuer returns true if id names a certificate signed by the responder's
+// issuer.
+func (r *Responder) matchesIssuer(id *certID) bool {
+	var h crypto.Hash
+	switch {
+	case id.HashAlgorithm.Algorithm.Equal(idSHA1):
+		h = crypto.SHA1
+	case id.HashAlgorithm.Algorithm.Equal(idSHA256):
+		h = crypto.SHA256
+	default:
+		return false
+	}
+	keyHash, err := subjectKeyHash(r.Issuer, h)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(id.NameHash, hash(h, r.Issuer.RawSubject)) &&
+		bytes.Equal(id.IssuerKeyHash, keyHash)
+}
+
+// Respond produces a DER encoded OCSP response for a DER encoded OCSP request.
+// Malformed requests get a malformedRequest response rather than an error.
+func (r *Responder) Respond(der []byte) ([]byte, error) {
+	var req ocspRequest
+	if rest, err := asn1.Unmarshal(der, &req); err != nil || len(rest) > 0 || len(req.TBSRequest.RequestList) == 0 {
+		return asn1.Marshal(ocspResponse{Status: malformedRequest})
+	}
+
+	// RFC 6960 times are GeneralizedTime in UTC, without fractional seconds.
+	now := time.Now().UTC().Truncate(time.Second)
+	var responses []singleResponse
+	for _, q := range req.TBSRequest.RequestList {
+		sr := singleResponse{
+			CertID:     q.Cert,
+			ThisUpdate: now,
+			NextUpdate: now.Add(r.Period),
+		}
+		cs := CertStatus{Status: Unknown}
+		if r.matchesIssuer(&q.Cert) {
+			cs = r.Lookup(q.Cert.SerialNumber)
+		}
+		switch cs.Status {
+		case Good:
+			sr.Good = true
+		case Revoked:
+			sr.Revoked = revokedInfo{
+				RevocationTime: cs.RevocationTime.UTC(),
+				Reason:         asn1.Enumerated(cs.Reason),
+			}
+		default:
+			sr.Unknown = true
+		}
+		responses = append(responses, sr)
+	}
+
+	keyHash, err := subjectKeyHash(r.SignerCert, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	byKey, err := asn1.Marshal(keyHash)
+	if err != nil {
+		return nil, err
+	}
+	tbs, err := asn1.Marshal(responseData{
+		ResponderID: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 2, IsCompound: true, Bytes: byKey},
+		ProducedAt:  now,
+		Responses:   responses,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sigAlg asn1.ObjectIdentifier
+	switch r.Signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		sigAlg = idECDSAWithSHA256
+	case *rsa.PublicKey:
+		sigAlg = idSHA256WithRSA
+	default:
+		return nil, fmt.Errorf("unsupported OCSP signing key")
+	}
+	digest := sha256.Sum256(tbs)
+	sig, err := r.Signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	basic := basicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: sigAlg},
+		Signature:          asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)},
+	}
+	if r.SignerCert != r.Issuer {
+		basic.Certificates = []asn1.RawValue{{FullBytes: r.SignerCert.Raw}}
+	}
+	basicBytes, err := asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspResponse{
+		Status: successful,
+		Response: responseBytes{
+			ResponseType: idPKIXOCSPBasic,
+			Response:     basicBytes,
+		},
+	})
+}
+
+// ServeHTTP handles OCSP requests using either GET or POST, as described in
+// RFC 6960, appendix A.
+func (r *Responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var der []byte
+	var err error
+	switch req.Method {
+	case "GET":
+		s := strings.TrimPrefix(req.URL.Path, "/")
+		if s, err = url.QueryUnescape(s); err == nil {
+			der, err = base64.StdEncoding.DecodeString(s)
+		}
+	case "POST":
+		der, err = ioutil.ReadAll(http.MaxBytesReader(w, req.Body, 10000))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err != nil {
+		der = nil
+	}
+	resp, err := r.Respond(der)
+	if err != nil {
+		fmt.Printf("error producing OCSP response: %s\n", err)
+		resp, _ = asn1.Marshal(ocspResponse{Status: internalError})
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(resp)
+}
+
+// CreateRequest produces a DER encoded OCSP request for a certificate.
+func CreateRequest(cert, issuer *x509.Certificate) ([]byte, error) {
+	keyHash, err := subjectKeyHash(issuer, crypto.SHA1)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(ocspRequest{
+		TBSRequest: tbsRequest{
+			RequestList: []request{{
+				Cert: certID{
+					HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: idSHA1, Parameters: asn1.RawValue{Tag: asn1.TagNull}},
+					NameHash:      hash(crypto.SHA1, issuer.RawSubject),
+					IssuerKeyHash: keyHash,
+					SerialNumber:  cert.SerialNumber,
+				},
+			}},
+		},
+	})
+}
+
+// ParseResponse checks a DER encoded OCSP response for a certificate, which
+// must be signed by issuer or by a delegated OCSP signing certificate.
+func ParseResponse(der []byte, cert, issuer *x509.Certificate) (*CertStatus, error) {
+	var resp ocspResponse
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Status != successful {
+		return nil, fmt.Errorf("OCSP responder returned status %d", resp.Status)
+	}
+	if !resp.Response.ResponseType.Equal(idPKIXOCSPBasic) {
+		return nil, fmt.Errorf("unsupported OCSP response type")
+	}
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+	var data responseData
+	if _, err := asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data); err != nil {
+		return nil, err
+	}
+
+	signer := issuer
+	if len(basic.Certificates) > 0 {
+		c, err := x509.ParseCertificate(basic.Certificates[0].FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.CheckSignatureFrom(issuer); err != nil {
+			return nil, fmt.Errorf("bad OCSP signing certificate: %s", err)
+		}
+		ok := false
+		for _, u := range c.ExtKeyUsage {
+			ok = ok || u == x509.ExtKeyUsageOCSPSigning
+		}
+		if !ok {
+			return nil, fmt.Errorf("OCSP signing certificate lacks OCSP signing usage")
+		}
+		signer = c
+	}
+	var alg x509.SignatureAlgorithm
+	switch {
+	case basic.SignatureAlgorithm.Algorithm.Equal(idECDSAWithSHA256):
+		alg = x509.ECDSAWithSHA256
+	case basic.SignatureAlgorithm.Algorithm.Equal(idSHA256WithRSA):
+		alg = x509.SHA256WithRSA
+	default:
+		return nil, fmt.Errorf("unsupported OCSP signature algorithm")
+	}
+	if err := signer.CheckSignature(alg, basic.TBSResponseData.FullBytes, basic.Signature.RightAlign()); err != nil {
+		return nil, fmt.Errorf("bad OCSP response signature: %s", err)
+	}
+
+	for _, sr := range data.Responses {
+		if sr.CertID.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+		if !sr.NextUpdate.IsZero() && time.Now().After(sr.NextUpdate) {
+			return nil, fmt.Errorf("stale OCSP response")
+		}
+		switch {
+		case bool(sr.Good):
+			return &CertStatus{Status: Good}, nil
+		case !sr.Revoked.RevocationTime.IsZero():
+			return &CertStatus{
+				Status:         Revoked,
+				RevocationTime: sr.Revoked.RevocationTime,
+				Reason:         int(sr.Revoked.Reason),
+			}, nil
+		default:
+			return &CertStatus{Status: Unknown}, nil
+		}
+	}
+	return nil, fmt.Errorf("no OCSP response for serial %v", cert.SerialNumber)
+}
@@ -0,0 +1,210 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ocsp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newCert(t *testing.T, serial int64, template, parent *x509.Certificate, key, parentKey *ecdsa.PrivateKey) *x509.Certificate {
+	template.SerialNumber = big.NewInt(serial)
+	template.Subject = pkix.Name{CommonName: "test " + template.SerialNumber.String()}
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	if parent == nil {
+		parent = template
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func newKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestResponder(t *testing.T) {
+	caKey, ocspKey, leafKey := newKey(t), newKey(t), newKey(t)
+	ca := newCert(t, 1, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, caKey, caKey)
+	signer := newCert(t, 2, &x509.Certificate{
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning},
+		ExtraExtensions: []pkix.Extension{NoCheckExtension()},
+	}, ca, ocspKey, caKey)
+	good := newCert(t, 3, &x509.Certificate{}, ca, leafKey, caKey)
+	revoked := newCert(t, 4, &x509.Certificate{}, ca, leafKey, caKey)
+	unknown := newCert(t, 5, &x509.Certificate{}, ca, leafKey, caKey)
+
+	revokedAt := time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+	r := &Responder{
+		Issuer:     ca,
+		Signer:     ocspKey,
+		SignerCert: signer,
+		Period:     time.Hour,
+		Lookup: func(serial *big.Int) CertStatus {
+			switch serial.Int64() {
+			case 3:
+				return CertStatus{Status: Good}
+			case 4:
+				return CertStatus{Status: Revoked, RevocationTime: revokedAt, Reason: 1}
+			default:
+				return CertStatus{Status: Unknown}
+			}
+		},
+	}
+
+	for _, c := range []struct {
+		cert   *x509.Certificate
+		status Status
+	}{{good, Good}, {revoked, Revoked}, {unknown, Unknown}} {
+		req, err := CreateRequest(c.cert, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := r.Respond(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cs, err := ParseResponse(resp, c.cert, ca)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cs.Status != c.status {
+			t.Fatalf("serial %v: expected %s, got %s", c.cert.SerialNumber, c.status, cs.Status)
+		}
+		if c.status == Revoked && (!cs.RevocationTime.Equal(revokedAt) || cs.Reason != 1) {
+			t.Fatalf("bad revocation details: %v", cs)
+		}
+	}
+
+	// A certificate from a different issuer is unknown.
+	other := newCert(t, 3, &x509.Certificate{}, nil, leafKey, leafKey)
+	req, err := CreateRequest(good, other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.Respond(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs, err := ParseResponse(resp, good, ca); err != nil || cs.Status != Unknown {
+		t.Fatalf("expected unknown status for other issuer: %v %v", cs, err)
+	}
+
+	// Garbage gets a malformedRequest response.
+	resp, err = r.Respond([]byte("garbage"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseResponse(resp, good, ca); err == nil {
+		t.Fatal("expected error for malformed request")
+	}
+}
+
+// generalizedTimes returns the contents of each GeneralizedTime in DER data.
+func generalizedTimes(t *testing.T, der []byte) []string {
+	var times []string
+	for len(der) > 0 {
+		var v asn1.RawValue
+		rest, err := asn1.Unmarshal(der, &v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagGeneralizedTime:
+			times = append(times, string(v.Bytes))
+		case v.IsCompound:
+			times = append(times, generalizedTimes(t, v.Bytes)...)
+		case v.Class == asn1.ClassUniversal && v.Tag == asn1.TagOctetString:
+			// The basic response is wrapped in an octet string.
+			if _, err := asn1.Unmarshal(v.Bytes, &asn1.RawValue{}); err == nil {
+				times = append(times, generalizedTimes(t, v.Bytes)...)
+			}
+		}
+		der = rest
+	}
+	return times
+}
+
+func TestResponseTimesAreUTC(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("EST", -5*60*60)
+
+	caKey, leafKey := newKey(t), newKey(t)
+	ca := newCert(t, 1, &x509.Certificate{
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, caKey, caKey)
+	revoked := newCert(t, 4, &x509.Certificate{}, ca, leafKey, caKey)
+	revokedAt := time.Now().Add(-time.Minute).Truncate(time.Second)
+	r := &Responder{
+		Issuer:     ca,
+		Signer:     caKey,
+		SignerCert: ca,
+		Period:     time.Hour,
+		Lookup: func(serial *big.Int) CertStatus {
+			return CertStatus{Status: Revoked, RevocationTime: revokedAt, Reason: 1}
+		},
+	}
+	req, err := CreateRequest(revoked, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := r.Respond(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := ParseResponse(resp, revoked, ca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cs.Status != Revoked || !cs.RevocationTime.Equal(revokedAt) {
+		t.Fatalf("bad revocation details: %v", cs)
+	}
+
+	// ProducedAt, ThisUpdate, NextUpdate, and RevocationTime, plus the
+	// validity times of the embedded certificate.
+	times := generalizedTimes(t, resp)
+	if len(times) < 4 {
+		t.Fatalf("expected at least 4 GeneralizedTimes, found %q", times)
+	}
+	for _, s := range times {
+		if len(s) != len("20060102150405Z") || s[len(s)-1] != 'Z' {
+			t.Errorf("GeneralizedTime %q is not in Zulu time", s)
+		}
+	}
+}
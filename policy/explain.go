@@ -0,0 +1,246 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+// MaxClosest limits the number of rules given in the explanation of a denial.
+var MaxClosest = 3
+
+// Explanation describes why a guard allowed or denied a request.
+type Explanation struct {
+	// Allowed is true if the request was authorized.
+	Allowed bool `json:"allowed"`
+
+	// Goals are the authorization goals that were checked.
+	Goals []string `json:"goals"`
+
+	// Rules are the rules that authorized the request, i.e. the matching ACL
+	// entry or the rules used in the datalog derivation. For a denial, these
+	// are instead the rules that came closest to authorizing the request.
+	Rules []string `json:"rules,omitempty"`
+}
+
+func (e *Explanation) String() string {
+	var s string
+	if e.Allowed {
+		s = fmt.Sprintf("allowed: %s", strings.Join(e.Goals, " and "))
+		if len(e.Rules) > 0 {
+			s += "\n  by rules:\n    " + strings.Join(e.Rules, "\n    ")
+		}
+	} else {
+		s = fmt.Sprintf("denied: %s", strings.Join(e.Goals, " and "))
+		if len(e.Rules) > 0 {
+			s += "\n  closest rules:\n    " + strings.Join(e.Rules, "\n    ")
+		} else {
+			s += "\n  no similar rules"
+		}
+	}
+	return s
+}
+
+// Goal formats the authorization goal for prin to perform op with args, as it
+// would appear in a policy rule.
+func Goal(prin auth.Prin, op string, args []string) string {
+	s := fmt.Sprintf("Authorized(%v, %q", prin, op)
+	for _, arg := range args {
+		s += fmt.Sprintf(", %q", arg)
+	}
+	return s + ")"
+}
+
+// Explain checks whether g authorizes prin to perform op with args, and
+// explains the decision.
+func Explain(g tao.Guard, prin auth.Prin, op string, args []string) *Explanation {
+	e := &Explanation{
+		Allowed: g.IsAuthorized(prin, op, args),
+		Goals:   []string{Goal(prin, op, args)},
+	}
+	if e.Allowed {
+		e.Rules = cachedDerivation(g, prin, op, args)
+	} else {
+		e.Rules = closest(g, prin, op, args)
+	}
+	return e
+}
+
+// Merge combines explanations for several goals that must all be authorized.
+// If any is denied, only the denials are kept.
+func Merge(explanations ...*Explanation) *Explanation {
+	m := &Explanation{Allowed: true}
+	for _, e := range explanations {
+		if !e.Allowed && m.Allowed {
+			m = &Explanation{}
+		}
+		if e.Allowed == m.Allowed {
+			m.Goals = append(m.Goals, e.Goals...)
+			m.Rules = appendNew(m.Rules, e.Rules...)
+		}
+	}
+	return m
+}
+
+func appendNew(rules []string, more ...string) []string {
+	for _, r := range more {
+		dup := false
+		for _, s := range rules {
+			if r == s {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			rules = append(rules, r)
+		}
+	}
+	return rules
+}
+
+// rules returns the rules held by g.
+func rules(g tao.Guard) []string {
+	n := g.RuleCount()
+	r := make([]string, n)
+	for i := 0; i < n; i++ {
+		r[i] = g.GetRule(i)
+	}
+	return r
+}
+
+// newGuardLike returns a new, empty guard of the same kind as g, holding only
+// the given rules.
+func newGuardLike(g tao.Guard, rules []string) (tao.Guard, error) {
+	var h tao.Guard
+	switch g.(type) {
+	case *tao.ACLGuard:
+		h = tao.NewACLGuard()
+	case *tao.DatalogGuard:
+		h = tao.NewTemporaryDatalogGuard()
+	default:
+		return nil, fmt.Errorf("unsupported guard type %T", g)
+	}
+	for _, r := range rules {
+		if err := h.AddRule(r); err != nil {
+			return nil, err
+		}
+	}
+	return h, nil
+}
+
+// derivations caches the derivations found for the most recently used guard.
+// Finding a derivation can build a guard for each rule left out, so it is done
+// once per goal rather than for every request. The cache is discarded when
+// another guard is used, or when rules are added to the guard.
+var derivations struct {
+	sync.Mutex
+	g     tao.Guard
+	n     int
+	rules map[string][]string
+}
+
+// maxDerivations bounds the number of cached derivations.
+const maxDerivations = 1000
+
+// cachedDerivation is like derivation, but uses the cache when it can.
+func cachedDerivation(g tao.Guard, prin auth.Prin, op string, args []string) []string {
+	goal := Goal(prin, op, args)
+	n := g.RuleCount()
+	derivations.Lock()
+	if derivations.g != g || derivations.n != n || len(derivations.rules) >= maxDerivations {
+		derivations.g, derivations.n = g, n
+		derivations.rules = make(map[string][]string)
+	}
+	r, ok := derivations.rules[goal]
+	derivations.Unlock()
+	if ok {
+		return r
+	}
+	r = derivation(g, prin, op, args)
+	derivations.Lock()
+	if derivations.g == g && derivations.n == n {
+		derivations.rules[goal] = r
+	}
+	derivations.Unlock()
+	return r
+}
+
+// derivation finds the rules that authorize an allowed request. An ACL entry,
+// or a datalog fact or rule, that suffices on its own is given if there is one.
+// Otherwise, the rules that the datalog derivation can not do without are
+// given.
+func derivation(g tao.Guard, prin auth.Prin, op string, args []string) []string {
+	all := rules(g)
+	for _, r := range all {
+		h, err := newGuardLike(g, []string{r})
+		if err == nil && h.IsAuthorized(prin, op, args) {
+			return []string{r}
+		}
+	}
+	var needed []string
+	for i, r := range all {
+		rest := append(append([]string{}, all[:i]...), all[i+1:]...)
+		h, err := newGuardLike(g, rest)
+		if err == nil && !h.IsAuthorized(prin, op, args) {
+			needed = append(needed, r)
+		}
+	}
+	return needed
+}
+
+// closest finds the rules that come closest to authorizing a denied request,
+// judged by how many parts of the goal each one mentions: the principal or its
+// subprincipals, the operation, and the arguments. Mentions of the principal
+// count double.
+func closest(g tao.Guard, prin auth.Prin, op string, args []string) []string {
+	base := prin
+	base.Ext = nil
+	parts := map[string]int{base.String(): 2, fmt.Sprintf("%q", op): 1}
+	for _, ext := range prin.Ext {
+		parts[ext.String()] = 2
+	}
+	for _, arg := range args {
+		parts[fmt.Sprintf("%q", arg)] = 1
+	}
+
+	type scored struct {
+		rule  string
+		score int
+	}
+	var candidates []scored
+	for _, r := range rules(g) {
+		score := 0
+		for p, weight := range parts {
+			if strings.Contains(r, p) {
+				score += weight
+			}
+		}
+		if score > 0 {
+			candidates = append(candidates, scored{r, score})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	var closest []string
+	for i := 0; i < len(candidates) && i < MaxClosest; i++ {
+		closest = append(closest, candidates[i].rule)
+	}
+	return closest
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+func TestExplain(t *testing.T) {
+	p := auth.NewKeyPrin([]byte("test key"))
+	q := auth.NewKeyPrin([]byte("other key"))
+	g := tao.NewACLGuard()
+	allowed := Goal(p, "ClaimCertificate", []string{"Test", "www.example.com"})
+	other := Goal(q, "ClaimCertificate", []string{"Test", "mail.example.com"})
+	for _, r := range []string{other, allowed} {
+		if err := g.AddRule(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e := Explain(g, p, "ClaimCertificate", []string{"Test", "www.example.com"})
+	if !e.Allowed || len(e.Rules) != 1 || e.Rules[0] != g.GetRule(1) {
+		t.Errorf("bad explanation for allowed request: %v", e)
+	}
+
+	e = Explain(g, p, "ClaimCertificate", []string{"Test", "mail.example.com"})
+	if e.Allowed || len(e.Rules) != 2 {
+		t.Errorf("bad explanation for denied request: %v", e)
+	} else if e.Rules[0] != g.GetRule(1) {
+		t.Errorf("expected rule for same principal to be closest: %v", e)
+	}
+
+	m := Merge(Explain(g, p, "ClaimCertificate", []string{"Test", "www.example.com"}), e)
+	if m.Allowed || len(m.Goals) != 1 {
+		t.Errorf("bad merged explanation: %v", m)
+	}
+}
+
+func TestDerivationCache(t *testing.T) {
+	p := auth.NewKeyPrin([]byte("test key"))
+	g := tao.NewACLGuard()
+	if err := g.AddRule(Goal(p, "ClaimCertificate", []string{"Test", "www.example.com"})); err != nil {
+		t.Fatal(err)
+	}
+	cached := func() int {
+		derivations.Lock()
+		defer derivations.Unlock()
+		if derivations.g != g {
+			return 0
+		}
+		return len(derivations.rules)
+	}
+
+	for i := 0; i < 2; i++ {
+		if e := Explain(g, p, "ClaimCertificate", []string{"Test", "www.example.com"}); !e.Allowed || len(e.Rules) != 1 {
+			t.Fatalf("bad explanation for allowed request: %v", e)
+		}
+		if n := cached(); n != 1 {
+			t.Fatalf("expected 1 cached derivation, found %d", n)
+		}
+	}
+
+	// Adding a rule discards the cache.
+	if err := g.AddRule(Goal(p, "ClaimCertificate", []string{"Test", "mail.example.com"})); err != nil {
+		t.Fatal(err)
+	}
+	if e := Explain(g, p, "ClaimCertificate", []string{"Test", "mail.example.com"}); !e.Allowed || e.Rules[0] != g.GetRule(1) {
+		t.Fatalf("bad explanation for allowed request: %v", e)
+	}
+	if n := cached(); n != 1 {
+		t.Fatalf("expected cache to be discarded, found %d derivations", n)
+	}
+}
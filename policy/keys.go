@@ -0,0 +1,123 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// KeyPolicy restricts the subject keys that can be certified.
+type KeyPolicy struct {
+	// Algorithms lists the allowed key algorithms, or is empty to allow all.
+	Algorithms []string
+
+	// MinStrength is the minimum security strength, in bits, or zero for no
+	// minimum.
+	MinStrength int
+}
+
+// KeyAlgorithm returns the name and approximate security strength, in bits, of
+// a public key's algorithm. Names are "ecdsa-p256", "ecdsa-p384",
+// "ecdsa-p521", "ed25519", or "rsa". Strengths follow NIST SP 800-57.
+func KeyAlgorithm(pub crypto.PublicKey) (string, int, error) {
+	switch k := pub.(type) {
+	case *ecdsa.PublicKey:
+		n := k.Curve.Params().BitSize
+		return fmt.Sprintf("ecdsa-p%d", n), n / 2, nil
+	case ed25519.PublicKey:
+		return "ed25519", 128, nil
+	case *rsa.PublicKey:
+		n := k.N.BitLen()
+		switch {
+		case n >= 15360:
+			return "rsa", 256, nil
+		case n >= 7680:
+			return "rsa", 192, nil
+		case n >= 3072:
+			return "rsa", 128, nil
+		case n >= 2048:
+			return "rsa", 112, nil
+		case n >= 1024:
+			return "rsa", 80, nil
+		default:
+			return "rsa", 0, nil
+		}
+	default:
+		return "", 0, fmt.Errorf("unsupported key type %T", pub)
+	}
+}
+
+// Check returns an error describing why a key is not allowed, if it is not.
+func (k *KeyPolicy) Check(pub crypto.PublicKey) error {
+	alg, strength, err := KeyAlgorithm(pub)
+	if err != nil {
+		return err
+	}
+	if len(k.Algorithms) > 0 {
+		ok := false
+		for _, a := range k.Algorithms {
+			ok = ok || a == alg
+		}
+		if !ok {
+			return fmt.Errorf("key algorithm %s is not allowed (allowed: %s)", alg, strings.Join(k.Algorithms, ", "))
+		}
+	}
+	if strength < k.MinStrength {
+		return fmt.Errorf("key strength %d bits (%s) is below the minimum of %d bits", strength, alg, k.MinStrength)
+	}
+	return nil
+}
+
+// parseKeyRule parses a key policy line of the form 'keyalgs <alg>,...' or
+// 'minkeystrength <bits>' into k. Only ecdsa-p256 can be allowed, and no more
+// than its 128 bits of strength can be required, since the Tao key layer
+// neither generates nor certifies other keys.
+func (k *KeyPolicy) parseKeyRule(line string) error {
+	f := strings.Fields(line)
+	if len(f) != 2 {
+		return fmt.Errorf("expected 'keyalgs <alg>,...' or 'minkeystrength <bits>'")
+	}
+	switch f[0] {
+	case "keyalgs":
+		for _, a := range strings.Split(f[1], ",") {
+			switch a {
+			case "ecdsa-p256":
+				k.Algorithms = append(k.Algorithms, a)
+			case "ecdsa-p384", "ecdsa-p521", "ed25519", "rsa":
+				return fmt.Errorf("key algorithm %q can't be certified by the Tao key layer", a)
+			default:
+				return fmt.Errorf("unrecognized key algorithm %q", a)
+			}
+		}
+	case "minkeystrength":
+		n, err := strconv.Atoi(f[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad key strength %q", f[1])
+		}
+		if n > 128 {
+			return fmt.Errorf("key strength %d bits is more than the Tao key layer can certify", n)
+		}
+		k.MinStrength = n
+	default:
+		return fmt.Errorf("expected 'keyalgs' or 'minkeystrength'")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestKeyPolicy(t *testing.T) {
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ed, _, _ := ed25519.GenerateKey(rand.Reader)
+	rsa1024, _ := rsa.GenerateKey(rand.Reader, 1024)
+
+	var k KeyPolicy
+	if err := k.parseKeyRule("keyalgs ecdsa-p256"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.parseKeyRule("minkeystrength 128"); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.parseKeyRule("keyalgs dsa"); err == nil {
+		t.Errorf("expected error for unknown algorithm")
+	}
+	if err := k.parseKeyRule("minkeystrength 192"); err == nil {
+		t.Errorf("expected error for strength the Tao can't certify")
+	}
+	for _, alg := range []string{"ecdsa-p384", "ecdsa-p521", "ed25519", "rsa", "ecdsa-p256,rsa"} {
+		var k KeyPolicy
+		if err := k.parseKeyRule("keyalgs " + alg); err == nil {
+			t.Errorf("%s: expected error for algorithm the Tao can't certify", alg)
+		}
+	}
+
+	if err := k.Check(&p256.PublicKey); err != nil {
+		t.Errorf("ecdsa-p256: %s", err)
+	}
+	if err := k.Check(&p384.PublicKey); err == nil {
+		t.Errorf("ecdsa-p384: expected algorithm not to be allowed")
+	}
+	if err := k.Check(ed); err == nil {
+		t.Errorf("ed25519: expected algorithm not to be allowed")
+	}
+	var weak KeyPolicy
+	weak.parseKeyRule("minkeystrength 112")
+	if err := weak.Check(&rsa1024.PublicKey); err == nil {
+		t.Errorf("rsa-1024: expected key to be too weak")
+	}
+	var none KeyPolicy
+	if err := none.Check(&rsa1024.PublicKey); err != nil {
+		t.Errorf("empty policy: %s", err)
+	}
+}
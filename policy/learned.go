@@ -0,0 +1,187 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Learned is a rule learned by a CA in learn mode, pending review. Learned
+// rules are kept in a file separate from the policy, one JSON record per line,
+// until they are promoted into the policy or discarded.
+type Learned struct {
+	// Rule is the policy rule that was learned.
+	Rule string `json:"rule"`
+
+	// Principal is the requesting principal the rule was learned from.
+	Principal string `json:"principal"`
+
+	// Time is when the rule was learned.
+	Time time.Time `json:"time"`
+
+	// OU and CN are the names requested when the rule was learned.
+	OU string `json:"ou,omitempty"`
+	CN string `json:"cn,omitempty"`
+}
+
+func (l *Learned) String() string {
+	return fmt.Sprintf("%s (learned %s from %s for ou=%q cn=%q)",
+		l.Rule, l.Time.Format(time.RFC3339), l.Principal, l.OU, l.CN)
+}
+
+// LoadLearned reads the learned rules in a file. A missing file holds no rules.
+func LoadLearned(path string) ([]*Learned, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var learned []*Learned
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 1024*1024)
+	n := 0
+	for s.Scan() {
+		n++
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+		l := new(Learned)
+		if err := json.Unmarshal([]byte(line), l); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, n, err)
+		}
+		learned = append(learned, l)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return learned, nil
+}
+
+// LockLearned takes an exclusive lock on a file of learned rules, waiting until
+// it is available, and returns a function that releases the lock. The lock
+// should be held while reading, rewriting, and saving the rules, since
+// AppendLearned also takes it, and a rule appended in the meantime would be
+// lost. The lock is kept in a separate file, since SaveLearned replaces the
+// file holding the rules.
+func LockLearned(path string) (unlock func(), err error) {
+	f, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() { f.Close() }, nil
+}
+
+// AppendLearned durably adds a learned rule to a file, holding the lock on the
+// file while doing so. See LockLearned.
+func AppendLearned(path string, l *Learned) error {
+	line, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	unlock, err := LockLearned(path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(line); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// SaveLearned replaces the contents of a file with the given learned rules.
+// Callers should hold the lock on the file. See LockLearned.
+func SaveLearned(path string, learned []*Learned) error {
+	var data []byte
+	for _, l := range learned {
+		line, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		data = append(append(data, line...), '\n')
+	}
+	return replaceFile(path, data, 0600)
+}
+
+// Promote appends learned rules to a policy file, each preceded by a comment
+// noting where it came from. The new policy is checked before the file is
+// replaced. It returns the SHA-256 hash of the new policy file.
+func Promote(path string, learned []*Learned) ([]byte, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	for _, l := range learned {
+		data = append(data, fmt.Sprintf("# learned %s from %s for ou=%q cn=%q\n%s\n",
+			l.Time.Format(time.RFC3339), l.Principal, l.OU, l.CN, l.Rule)...)
+	}
+	tmp := path + ".new"
+	if err := ioutil.WriteFile(tmp, data, fi.Mode().Perm()); err != nil {
+		return nil, err
+	}
+	if _, err := LoadPolicy(tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	h := sha256.Sum256(data)
+	return h[:], nil
+}
+
+// replaceFile atomically replaces the contents of a file.
+func replaceFile(path string, data []byte, perm os.FileMode) error {
+	tmp := path + ".new"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+func TestLearned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "learned_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lpath := path.Join(dir, "learned")
+	ppath := path.Join(dir, "policy")
+
+	learned, err := LoadLearned(lpath)
+	if err != nil || len(learned) != 0 {
+		t.Fatalf("expected no learned rules, got %v, %v", learned, err)
+	}
+
+	p := auth.NewKeyPrin([]byte("test key"))
+	now := time.Now()
+	for _, cn := range []string{"a.example.com", "b.example.com"} {
+		l := &Learned{
+			Rule:      Goal(p, "ClaimCertificate", []string{"Test", cn}),
+			Principal: p.String(),
+			Time:      now,
+			OU:        "Test",
+			CN:        cn,
+		}
+		if err := AppendLearned(lpath, l); err != nil {
+			t.Fatal(err)
+		}
+	}
+	learned, err = LoadLearned(lpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(learned) != 2 || learned[1].CN != "b.example.com" {
+		t.Fatalf("bad learned rules: %v", learned)
+	}
+
+	if err := ioutil.WriteFile(ppath, []byte("acl\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Promote(ppath, learned[:1]); err != nil {
+		t.Fatal(err)
+	}
+	pol, err := LoadPolicy(ppath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pol.Guard.IsAuthorized(p, "ClaimCertificate", []string{"Test", "a.example.com"}) {
+		t.Errorf("promoted rule is not in policy")
+	}
+	if pol.Guard.IsAuthorized(p, "ClaimCertificate", []string{"Test", "b.example.com"}) {
+		t.Errorf("unpromoted rule is in policy")
+	}
+
+	bad := &Learned{Rule: "Authorized(", Principal: p.String(), Time: now}
+	if _, err := Promote(ppath, []*Learned{bad}); err == nil {
+		t.Errorf("expected error promoting bad rule")
+	}
+
+	if err := SaveLearned(lpath, learned[1:]); err != nil {
+		t.Fatal(err)
+	}
+	learned, err = LoadLearned(lpath)
+	if err != nil || len(learned) != 1 || learned[0].CN != "b.example.com" {
+		t.Fatalf("bad learned rules after save: %v, %v", learned, err)
+	}
+}
+
+func TestLockLearned(t *testing.T) {
+	dir, err := ioutil.TempDir("", "learned_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lpath := path.Join(dir, "learned")
+
+	p := auth.NewKeyPrin([]byte("test key"))
+	newRule := func(cn string) *Learned {
+		return &Learned{
+			Rule:      Goal(p, "ClaimCertificate", []string{"Test", cn}),
+			Principal: p.String(),
+			Time:      time.Now(),
+			OU:        "Test",
+			CN:        cn,
+		}
+	}
+	if err := AppendLearned(lpath, newRule("a.example.com")); err != nil {
+		t.Fatal(err)
+	}
+
+	// A rule learned during a review waits for the review to finish, rather
+	// than being overwritten by it.
+	unlock, err := LockLearned(lpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- AppendLearned(lpath, newRule("b.example.com")) }()
+	learned, err := LoadLearned(lpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("rule appended while file was locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if err := SaveLearned(lpath, learned[1:]); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	learned, err = LoadLearned(lpath)
+	if err != nil || len(learned) != 1 || learned[0].CN != "b.example.com" {
+		t.Fatalf("bad learned rules after review: %v, %v", learned, err)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+// Lifetime is a policy limit on the validity period of certificates. A limit
+// applies either to certificates for a given OU and CN, or to certificates
+// requested by a given principal or any of its subprincipals.
+type Lifetime struct {
+	// Max is the longest validity period allowed.
+	Max time.Duration
+
+	// OU and CN select the certificates to which the limit applies. A value of
+	// "*" or "" matches anything.
+	OU, CN string
+
+	// Prin, if not nil, selects the requesting principal to which the limit
+	// applies, along with its subprincipals.
+	Prin *auth.Prin
+}
+
+// ParseLifetime parses a lifetime limit from a policy file line of the form
+// 'lifetime <duration> [<ou> <cn> | <principal>]', where the OU and CN are
+// quoted strings. Durations are as for time.ParseDuration, but can also be given in days using
+// a "d" suffix, e.g. "30d".
+func ParseLifetime(line string) (*Lifetime, error) {
+	f := strings.SplitN(strings.TrimSpace(line), " ", 3)
+	if len(f) < 2 || f[0] != "lifetime" {
+		return nil, fmt.Errorf("expected 'lifetime <duration> ...'")
+	}
+	max, err := ParseDuration(f[1])
+	if err != nil {
+		return nil, err
+	}
+	if max <= 0 {
+		return nil, fmt.Errorf("lifetime must be positive")
+	}
+	l := &Lifetime{Max: max}
+	if len(f) < 3 {
+		return l, nil
+	}
+	rest := strings.TrimSpace(f[2])
+	if strings.HasPrefix(rest, `"`) {
+		if _, err := fmt.Sscanf(rest, "%q %q", &l.OU, &l.CN); err != nil {
+			return nil, fmt.Errorf("expected quoted OU and CN: %s", err)
+		}
+	} else {
+		var p auth.Prin
+		if _, err := fmt.Sscanf(rest, "%v", &p); err != nil {
+			return nil, fmt.Errorf("expected principal: %s", err)
+		}
+		l.Prin = &p
+	}
+	return l, nil
+}
+
+// ParseDuration is like time.ParseDuration, but it also accepts a whole number
+// of days using a "d" suffix, e.g. "30d".
+func ParseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, fmt.Errorf("bad duration %q", s)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// Applies checks whether the limit applies to a certificate for the given OU
+// and CN requested by prin.
+func (l *Lifetime) Applies(prin auth.Prin, ou, cn string) bool {
+	if l.Prin != nil {
+		return auth.SubprinOrIdentical(prin, *l.Prin)
+	}
+	return (l.OU == "" || l.OU == "*" || l.OU == ou) &&
+		(l.CN == "" || l.CN == "*" || l.CN == cn)
+}
+
+// MaxLifetime returns the most restrictive of the limits that apply to a
+// certificate for the given OU and CN requested by prin, or zero if there are
+// none.
+func MaxLifetime(limits []*Lifetime, prin auth.Prin, ou, cn string) time.Duration {
+	var max time.Duration
+	for _, l := range limits {
+		if l.Applies(prin, ou, cn) && (max == 0 || l.Max < max) {
+			max = l.Max
+		}
+	}
+	return max
+}
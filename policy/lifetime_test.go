@@ -0,0 +1,64 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+func TestLifetime(t *testing.T) {
+	var limits []*Lifetime
+	for _, line := range []string{
+		`lifetime 365d`,
+		`lifetime 24h "Ephemeral" "*"`,
+		`lifetime 72h "Ephemeral" "db.example.com"`,
+	} {
+		l, err := ParseLifetime(line)
+		if err != nil {
+			t.Fatalf("%s: %s", line, err)
+		}
+		limits = append(limits, l)
+	}
+	for _, line := range []string{
+		`lifetime`,
+		`lifetime forever`,
+		`lifetime -1h`,
+		`lifetime 24h "Ephemeral"`,
+	} {
+		if _, err := ParseLifetime(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+
+	var p auth.Prin
+	for _, c := range []struct {
+		ou, cn string
+		max    time.Duration
+	}{
+		{"Web", "www.example.com", 365 * 24 * time.Hour},
+		{"Ephemeral", "www.example.com", 24 * time.Hour},
+		{"Ephemeral", "db.example.com", 24 * time.Hour},
+	} {
+		if max := MaxLifetime(limits, p, c.ou, c.cn); max != c.max {
+			t.Errorf("ou=%q cn=%q: got %v, expected %v", c.ou, c.cn, max, c.max)
+		}
+	}
+	if max := MaxLifetime(nil, p, "Web", "www.example.com"); max != 0 {
+		t.Errorf("no limits: got %v, expected 0", max)
+	}
+}
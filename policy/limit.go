@@ -0,0 +1,127 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a policy limit on how many certificates can be issued. A rate limit
+// counts certificates issued within a period, while a quota counts outstanding
+// certificates, i.e. those that have neither expired nor been revoked. Either
+// way, certificates are counted separately for each requesting principal
+// ("principal"), each program hash subprincipal ("program"), or each CN ("cn").
+type Limit struct {
+	// Count is the number of certificates allowed.
+	Count int
+
+	// Period is the period for a rate limit, or zero for a quota.
+	Period time.Duration
+
+	// Key is one of "principal", "program", or "cn".
+	Key string
+}
+
+// ParseLimit parses a limit from a policy file line of the form 'ratelimit
+// <count> <period> <key>' or 'quota <count> <key>'.
+func ParseLimit(line string) (*Limit, error) {
+	f := strings.Fields(line)
+	l := &Limit{}
+	var count, key string
+	switch {
+	case len(f) == 4 && f[0] == "ratelimit":
+		period, err := ParseDuration(f[2])
+		if err != nil {
+			return nil, err
+		}
+		if period <= 0 {
+			return nil, fmt.Errorf("period must be positive")
+		}
+		count, l.Period, key = f[1], period, f[3]
+	case len(f) == 3 && f[0] == "quota":
+		count, key = f[1], f[2]
+	default:
+		return nil, fmt.Errorf("expected 'ratelimit <count> <period> <key>' or 'quota <count> <key>'")
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("bad count %q", count)
+	}
+	l.Count = n
+	switch key {
+	case "principal", "program", "cn":
+		l.Key = key
+	default:
+		return nil, fmt.Errorf("expected 'principal', 'program', or 'cn', found %q", key)
+	}
+	return l, nil
+}
+
+// String returns a description of the limit.
+func (l *Limit) String() string {
+	if l.Period == 0 {
+		return fmt.Sprintf("at most %d outstanding certificates per %s", l.Count, l.Key)
+	}
+	return fmt.Sprintf("at most %d certificates per %s every %v", l.Count, l.Key, l.Period)
+}
+
+// Usage is a certificate counted against limits.
+type Usage struct {
+	// Issued is when the certificate was issued.
+	Issued time.Time
+
+	// Expires is when the certificate stops counting against quotas, i.e. when
+	// it expires or, if sooner, when it was revoked.
+	Expires time.Time
+}
+
+// Exceeded checks whether issuing another certificate at time now would exceed
+// the limit, given the certificates already issued for the same key. If so, it
+// also returns how long until one of those stops counting against the limit.
+func (l *Limit) Exceeded(used []*Usage, now time.Time) (bool, time.Duration) {
+	n := 0
+	var first time.Time
+	for _, u := range used {
+		until := l.until(u)
+		if !until.After(now) {
+			continue
+		}
+		n++
+		if first.IsZero() || until.Before(first) {
+			first = until
+		}
+	}
+	if n < l.Count {
+		return false, 0
+	}
+	return true, first.Sub(now)
+}
+
+// Counts reports whether a certificate still counts against the limit at time
+// now. Once it stops counting, it never counts again.
+func (l *Limit) Counts(u *Usage, now time.Time) bool {
+	return l.until(u).After(now)
+}
+
+// until returns when a certificate stops counting against the limit.
+func (l *Limit) until(u *Usage) time.Time {
+	if l.Period > 0 {
+		return u.Issued.Add(l.Period)
+	}
+	return u.Expires
+}
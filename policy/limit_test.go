@@ -0,0 +1,116 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		l    Limit
+	}{
+		{`ratelimit 5 1h principal`, Limit{5, time.Hour, "principal"}},
+		{`ratelimit 10 7d program`, Limit{10, 7 * 24 * time.Hour, "program"}},
+		{`quota 3 cn`, Limit{3, 0, "cn"}},
+	} {
+		l, err := ParseLimit(c.line)
+		if err != nil {
+			t.Fatalf("%s: %s", c.line, err)
+		}
+		if *l != c.l {
+			t.Errorf("%s: got %+v, expected %+v", c.line, *l, c.l)
+		}
+	}
+	for _, line := range []string{
+		`ratelimit`,
+		`ratelimit 5 1h`,
+		`ratelimit 5 0h principal`,
+		`ratelimit 5 -1h principal`,
+		`ratelimit 5 forever principal`,
+		`ratelimit 0 1h principal`,
+		`ratelimit five 1h principal`,
+		`ratelimit 5 1h ou`,
+		`quota 3`,
+		`quota -3 cn`,
+		`quota 3 1h cn`,
+		`limit 3 cn`,
+	} {
+		if _, err := ParseLimit(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+}
+
+func TestRateLimitWindow(t *testing.T) {
+	now := time.Now()
+	l := &Limit{Count: 2, Period: time.Hour, Key: "principal"}
+	far := now.Add(365 * 24 * time.Hour)
+	used := []*Usage{
+		{Issued: now.Add(-90 * time.Minute), Expires: far},
+		{Issued: now.Add(-30 * time.Minute), Expires: far},
+	}
+	if exceeded, _ := l.Exceeded(used, now); exceeded {
+		t.Fatal("certificate issued before the window should not count")
+	}
+	if l.Counts(used[0], now) || !l.Counts(used[1], now) {
+		t.Fatal("only the certificate issued within the window should count")
+	}
+
+	used = append(used, &Usage{Issued: now.Add(-10 * time.Minute), Expires: now})
+	exceeded, wait := l.Exceeded(used, now)
+	if !exceeded {
+		t.Fatal("limit should be exceeded, even though a certificate is revoked")
+	}
+	if wait != 30*time.Minute {
+		t.Fatalf("expected to wait 30m, got %v", wait)
+	}
+
+	// The window is open at its start: a certificate issued exactly one
+	// period ago no longer counts.
+	if exceeded, _ := l.Exceeded(used, now.Add(30*time.Minute)); exceeded {
+		t.Fatal("limit should not be exceeded once the oldest certificate leaves the window")
+	}
+}
+
+func TestQuota(t *testing.T) {
+	now := time.Now()
+	l := &Limit{Count: 2, Key: "cn"}
+	used := []*Usage{
+		{Issued: now.Add(-48 * time.Hour), Expires: now.Add(2 * time.Hour)},
+		{Issued: now.Add(-24 * time.Hour), Expires: now.Add(time.Hour)},
+	}
+	exceeded, wait := l.Exceeded(used, now)
+	if !exceeded {
+		t.Fatal("quota should be exceeded")
+	}
+	if wait != time.Hour {
+		t.Fatalf("expected to wait 1h, got %v", wait)
+	}
+
+	// Expired or revoked certificates don't count.
+	used[1].Expires = now
+	if exceeded, _ := l.Exceeded(used, now); exceeded {
+		t.Fatal("quota should not count a certificate that stops counting now")
+	}
+	if l.Counts(used[1], now) || !l.Counts(used[0], now) {
+		t.Fatal("only the unexpired certificate should count")
+	}
+	if exceeded, _ := l.Exceeded(nil, now); exceeded {
+		t.Fatal("quota should not be exceeded with no certificates")
+	}
+}
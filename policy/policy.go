@@ -16,34 +16,95 @@ package policy
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
 )
 
+// Policy holds a certificate-granting policy.
+type Policy struct {
+	// Guard decides which principals may claim which certificates.
+	Guard tao.Guard
+
+	// Lifetimes limit the validity periods of certificates.
+	Lifetimes []*Lifetime
+
+	// Subsidiaries constrain the certificates issued to subsidiary CAs.
+	Subsidiaries []*Subsidiary
+
+	// Limits restrict how many certificates can be issued.
+	Limits []*Limit
+
+	// Keys restricts the subject keys that can be certified.
+	Keys KeyPolicy
+}
+
 func Load(path string) (tao.Guard, error) {
+	p, err := LoadPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.Guard, nil
+}
+
+// LoadPolicy loads a policy file, including any lifetime limits.
+func LoadPolicy(path string) (*Policy, error) {
 	s, err := NewScanner(path)
 	if err != nil {
 		return nil, err
 	}
 	t := s.NextLine()
-	var g tao.Guard
+	p := &Policy{}
 	switch t {
 	case "acl":
-		g = tao.NewACLGuard()
+		p.Guard = tao.NewACLGuard()
 	case "datalog":
-		g = tao.NewTemporaryDatalogGuard()
+		p.Guard = tao.NewTemporaryDatalogGuard()
 	case "":
 		return nil, fmt.Errorf("%s: first line must specify 'datalog' or 'acl'\n", path)
 	default:
 		return nil, fmt.Errorf("%s: expected 'datalog' or 'acl', found %q\n", path, t)
 	}
 	for line := s.NextLine(); line != ""; line = s.NextLine() {
-		err = g.AddRule(line)
+		if strings.HasPrefix(line, "lifetime ") {
+			var l *Lifetime
+			l, err = ParseLifetime(line)
+			p.Lifetimes = append(p.Lifetimes, l)
+		} else if strings.HasPrefix(line, "subsidiary ") || line == "subsidiary" {
+			var sub *Subsidiary
+			sub, err = ParseSubsidiary(line)
+			p.Subsidiaries = append(p.Subsidiaries, sub)
+		} else if strings.HasPrefix(line, "ratelimit ") || strings.HasPrefix(line, "quota ") {
+			var l *Limit
+			l, err = ParseLimit(line)
+			p.Limits = append(p.Limits, l)
+		} else if strings.HasPrefix(line, "keyalgs ") || strings.HasPrefix(line, "minkeystrength ") {
+			err = p.Keys.parseKeyRule(line)
+		} else {
+			err = p.Guard.AddRule(line)
+		}
 		if err != nil {
 			return nil, fmt.Errorf("%s: %s; processing this line:\n> %s\n", path, err, line)
 		}
 	}
-	return g, nil
+	return p, nil
+}
+
+func LoadPrincipals(path string) ([]auth.Prin, error) {
+	s, err := NewScanner(path)
+	if err != nil {
+		return nil, err
+	}
+	var prins []auth.Prin
+	for line := s.NextLine(); line != ""; line = s.NextLine() {
+		var p auth.Prin
+		if _, err := fmt.Sscanf(line, "%v", &p); err != nil {
+			return nil, fmt.Errorf("%s: %s; processing this line:\n> %s\n", path, err, line)
+		}
+		prins = append(prins, p)
+	}
+	return prins, nil
 }
 
 var Default = `# This file defines the certificate-granting policy for some instance of a
@@ -56,7 +117,9 @@ var Default = `# This file defines the certificate-granting policy for some inst
 # * Remaining lines introduce rules, one per line.
 #
 # For an ACL-based guard, each rule is a triplet containing OU, CN, Prin.
-# A wildcard '*' can be used for the OU and/or CN.
+# A wildcard '*' can be used for the OU and/or CN. Each subject alternative
+# name in a request (DNS name, IP address, or URI) must also be authorized, in
+# the place of CN.
 # For example:
 #   ACL
 #   Authorized("ClaimCertificate", key([...]).Program([...]), "Cloudproxy Password Checker" "192.168.1.3")
@@ -74,5 +137,43 @@ var Default = `# This file defines the certificate-granting policy for some inst
 #              implies TrustedHttpsServerInstance(P, OU, CN) \
 #   TrustedHttpsServer(ext.Program([....]))
 #
+# For either kind of guard, lines starting with "lifetime" limit the validity
+# period of certificates, either for all certificates, for a given OU and CN
+# (with '*' as a wildcard), or for a given principal and its subprincipals.
+# Durations can be given in hours (e.g. "24h") or days (e.g. "30d"). Where
+# several limits apply, the shortest is used. For example:
+#   lifetime 365d
+#   lifetime 30d "Cloudproxy Password Checker" "*"
+#   lifetime 24h key([...]).Program([...])
+#
+# A CSR naming a certificate profile (e.g. "client" or "codesigning") is only
+# granted if the requesting principal is also authorized to use that profile,
+# e.g. using a rule like Authorized(P, "UseProfile", "client").
+#
+# Lines starting with "subsidiary" constrain the certificates issued to
+# subsidiary CAs, using X.509 name constraints and path length limits. The first
+# rule that applies to the requesting principal is used. A rule without a
+# principal applies to all. Without any applicable rule, subsidiary CA
+# certificates are unconstrained. For example:
+#   subsidiary pathlen=0 dns=.example.com,example.com ip=10.1.0.0/16 key([...]).Program([...])
+#   subsidiary pathlen=0 dns=.invalid
+#
+# Lines starting with "ratelimit" or "quota" limit how many certificates can be
+# issued, counted separately for each requesting principal, each program hash
+# subprincipal, or each CN. A rate limit counts certificates issued within a
+# period, and a quota counts outstanding (unexpired and unrevoked) certificates.
+# For example:
+#   ratelimit 5 24h cn
+#   quota 20 principal
+#
+# Lines starting with "keyalgs" or "minkeystrength" restrict the subject keys
+# that can be certified, by algorithm and by approximate security strength in
+# bits. For now, the Tao key layer only generates and certifies ecdsa-p256 keys,
+# with a strength of 128 bits, so that is the only algorithm that can be
+# listed. Other keys, such as rsa keys in ACME requests, are then refused. For
+# example:
+#   keyalgs ecdsa-p256
+#   minkeystrength 128
+#
 acl
 `
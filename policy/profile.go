@@ -0,0 +1,185 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Profile is a named set of certificate properties, such as key usages, that a
+// CSR can request. Profiles are part of the CA configuration, while the policy
+// decides which principals may use which profiles.
+type Profile struct {
+	// Name identifies the profile.
+	Name string
+
+	// IsCA is true if the profile is for subsidiary CA certificates.
+	IsCA bool
+
+	// KeyUsage and ExtKeyUsage are the key usages for certificates.
+	KeyUsage    x509.KeyUsage
+	ExtKeyUsage []x509.ExtKeyUsage
+
+	// MaxLifetime is the longest validity period allowed, or zero for no limit.
+	MaxLifetime time.Duration
+
+	// Extensions lists the optional extensions allowed in certificates, from
+	// "altnames" (subject alternative names), "crl" (CRL distribution points),
+	// and "ocsp" (OCSP responder location).
+	Extensions []string
+}
+
+// Allows checks whether the profile allows an optional extension.
+func (p *Profile) Allows(ext string) bool {
+	for _, e := range p.Extensions {
+		if e == ext {
+			return true
+		}
+	}
+	return false
+}
+
+var keyUsageNames = map[string]x509.KeyUsage{
+	"digitalSignature":  x509.KeyUsageDigitalSignature,
+	"contentCommitment": x509.KeyUsageContentCommitment,
+	"keyEncipherment":   x509.KeyUsageKeyEncipherment,
+	"dataEncipherment":  x509.KeyUsageDataEncipherment,
+	"keyAgreement":      x509.KeyUsageKeyAgreement,
+	"certSign":          x509.KeyUsageCertSign,
+	"crlSign":           x509.KeyUsageCRLSign,
+}
+
+var extKeyUsageNames = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverAuth":      x509.ExtKeyUsageServerAuth,
+	"clientAuth":      x509.ExtKeyUsageClientAuth,
+	"codeSigning":     x509.ExtKeyUsageCodeSigning,
+	"emailProtection": x509.ExtKeyUsageEmailProtection,
+	"timeStamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspSigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// DefaultProfiles are the profiles available unless the CA configuration
+// overrides them.
+var DefaultProfiles = []string{
+	"profile server keyusage=digitalSignature,keyAgreement extkeyusage=serverAuth extensions=altnames,crl,ocsp",
+	"profile client keyusage=digitalSignature,keyAgreement extkeyusage=clientAuth extensions=altnames,crl,ocsp",
+	"profile codesigning keyusage=digitalSignature extkeyusage=codeSigning extensions=crl,ocsp",
+	"profile ca ca keyusage=certSign,crlSign,digitalSignature extensions=crl,ocsp",
+}
+
+// ParseProfile parses a profile from a line of the form 'profile <name> [ca]
+// [keyusage=<usage>,...] [extkeyusage=<usage>,...] [lifetime=<duration>]
+// [extensions=<ext>,...]'.
+func ParseProfile(line string) (*Profile, error) {
+	f := strings.Fields(line)
+	if len(f) < 2 || f[0] != "profile" {
+		return nil, fmt.Errorf("expected 'profile <name> ...'")
+	}
+	p := &Profile{Name: f[1]}
+	for _, arg := range f[2:] {
+		if arg == "ca" {
+			p.IsCA = true
+			continue
+		}
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected <key>=<value>, found %q", arg)
+		}
+		vals := strings.Split(kv[1], ",")
+		switch kv[0] {
+		case "keyusage":
+			for _, v := range vals {
+				u, ok := keyUsageNames[v]
+				if !ok {
+					return nil, fmt.Errorf("unrecognized key usage %q", v)
+				}
+				p.KeyUsage |= u
+			}
+		case "extkeyusage":
+			for _, v := range vals {
+				u, ok := extKeyUsageNames[v]
+				if !ok {
+					return nil, fmt.Errorf("unrecognized extended key usage %q", v)
+				}
+				p.ExtKeyUsage = append(p.ExtKeyUsage, u)
+			}
+		case "lifetime":
+			d, err := ParseDuration(kv[1])
+			if err != nil {
+				return nil, err
+			}
+			p.MaxLifetime = d
+		case "extensions":
+			for _, v := range vals {
+				switch v {
+				case "altnames", "crl", "ocsp":
+					p.Extensions = append(p.Extensions, v)
+				default:
+					return nil, fmt.Errorf("unrecognized extension %q", v)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("unrecognized profile setting %q", kv[0])
+		}
+	}
+	return p, nil
+}
+
+// LoadProfiles returns the default profiles, along with any profiles defined in
+// a file, which override default profiles having the same name. If path is
+// empty, or the file does not exist, only the default profiles are returned.
+func LoadProfiles(path string) (map[string]*Profile, error) {
+	profiles := make(map[string]*Profile)
+	for _, line := range DefaultProfiles {
+		p, err := ParseProfile(line)
+		if err != nil {
+			panic(err)
+		}
+		profiles[p.Name] = p
+	}
+	if path == "" {
+		return profiles, nil
+	}
+	s, err := NewScanner(path)
+	if os.IsNotExist(err) {
+		return profiles, nil
+	} else if err != nil {
+		return nil, err
+	}
+	for line := s.NextLine(); line != ""; line = s.NextLine() {
+		p, err := ParseProfile(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s; processing this line:\n> %s\n", path, err, line)
+		}
+		profiles[p.Name] = p
+	}
+	return profiles, nil
+}
+
+// ProfileNames returns the sorted names of a set of profiles.
+func ProfileNames(profiles map[string]*Profile) []string {
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+)
+
+func TestProfile(t *testing.T) {
+	profiles, err := LoadProfiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"server", "client", "codesigning", "ca"} {
+		if profiles[name] == nil {
+			t.Errorf("missing default profile %q", name)
+		}
+	}
+	if !profiles["ca"].IsCA || profiles["server"].IsCA {
+		t.Errorf("bad IsCA for default profiles")
+	}
+	if profiles["codesigning"].Allows("altnames") {
+		t.Errorf("codesigning profile should not allow alternative names")
+	}
+
+	p, err := ParseProfile("profile mtls keyusage=digitalSignature extkeyusage=serverAuth,clientAuth lifetime=7d extensions=altnames")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Name != "mtls" || p.KeyUsage != x509.KeyUsageDigitalSignature || len(p.ExtKeyUsage) != 2 ||
+		p.MaxLifetime != 7*24*time.Hour || !p.Allows("altnames") || p.Allows("crl") {
+		t.Errorf("bad profile: %+v", p)
+	}
+
+	for _, line := range []string{
+		"profile",
+		"profile x keyusage=everything",
+		"profile x extkeyusage=serverAuth,bogus",
+		"profile x extensions=logo",
+		"profile x color=blue",
+		"profile x lifetime",
+	} {
+		if _, err := ParseProfile(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+}
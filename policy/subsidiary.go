@@ -0,0 +1,107 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+// Subsidiary holds policy constraints on the certificates issued to subsidiary
+// CAs. A rule applies either to every requesting principal, or to a given
+// principal and its subprincipals.
+type Subsidiary struct {
+	// MaxPathLen limits the number of CA certificates that can follow the
+	// subsidiary CA's certificate in a chain, or is -1 for no limit.
+	MaxPathLen int
+
+	// DNS lists the permitted DNS name subtrees, if any.
+	DNS []string
+
+	// IP lists the permitted IP address ranges, if any.
+	IP []*net.IPNet
+
+	// Prin, if not nil, selects the requesting principal to which the rule
+	// applies, along with its subprincipals.
+	Prin *auth.Prin
+}
+
+// ParseSubsidiary parses subsidiary CA constraints from a policy file line of
+// the form 'subsidiary [pathlen=<n>] [dns=<domain>,...] [ip=<cidr>,...]
+// [<principal>]'.
+func ParseSubsidiary(line string) (*Subsidiary, error) {
+	f := strings.Fields(line)
+	if len(f) == 0 || f[0] != "subsidiary" {
+		return nil, fmt.Errorf("expected 'subsidiary ...'")
+	}
+	s := &Subsidiary{MaxPathLen: -1}
+	i := 1
+	for ; i < len(f) && strings.Contains(f[i], "=") && !strings.Contains(f[i], "("); i++ {
+		kv := strings.SplitN(f[i], "=", 2)
+		switch kv[0] {
+		case "pathlen":
+			n, err := strconv.Atoi(kv[1])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("bad pathlen %q", kv[1])
+			}
+			s.MaxPathLen = n
+		case "dns":
+			for _, d := range strings.Split(kv[1], ",") {
+				if d == "" {
+					return nil, fmt.Errorf("empty DNS subtree")
+				}
+				s.DNS = append(s.DNS, d)
+			}
+		case "ip":
+			for _, r := range strings.Split(kv[1], ",") {
+				_, ipnet, err := net.ParseCIDR(r)
+				if err != nil {
+					return nil, err
+				}
+				s.IP = append(s.IP, ipnet)
+			}
+		default:
+			return nil, fmt.Errorf("unrecognized constraint %q", kv[0])
+		}
+	}
+	if i < len(f) {
+		var p auth.Prin
+		if _, err := fmt.Sscanf(strings.Join(f[i:], " "), "%v", &p); err != nil {
+			return nil, fmt.Errorf("expected principal: %s", err)
+		}
+		s.Prin = &p
+	}
+	return s, nil
+}
+
+// Applies checks whether the rule applies to a request by prin.
+func (s *Subsidiary) Applies(prin auth.Prin) bool {
+	return s.Prin == nil || auth.SubprinOrIdentical(prin, *s.Prin)
+}
+
+// FindSubsidiary returns the first of the rules that applies to a request by
+// prin, or nil if there is none.
+func FindSubsidiary(rules []*Subsidiary, prin auth.Prin) *Subsidiary {
+	for _, s := range rules {
+		if s.Applies(prin) {
+			return s
+		}
+	}
+	return nil
+}
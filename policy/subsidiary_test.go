@@ -0,0 +1,61 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package policy
+
+import (
+	"testing"
+
+	"github.com/jlmucb/cloudproxy/go/tao/auth"
+)
+
+func TestSubsidiary(t *testing.T) {
+	s, err := ParseSubsidiary("subsidiary pathlen=0 dns=.example.com,example.com ip=10.1.0.0/16")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MaxPathLen != 0 || len(s.DNS) != 2 || len(s.IP) != 1 || s.Prin != nil {
+		t.Errorf("bad constraints: %+v", s)
+	}
+	if s.IP[0].String() != "10.1.0.0/16" {
+		t.Errorf("bad IP range: %v", s.IP[0])
+	}
+
+	s, err = ParseSubsidiary("subsidiary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.MaxPathLen != -1 {
+		t.Errorf("expected unlimited path length, got %d", s.MaxPathLen)
+	}
+
+	for _, line := range []string{
+		"subsidiary pathlen=-1",
+		"subsidiary pathlen=x",
+		"subsidiary ip=10.1.0.0",
+		"subsidiary color=blue",
+	} {
+		if _, err := ParseSubsidiary(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+
+	var p auth.Prin
+	if FindSubsidiary([]*Subsidiary{s}, p) != s {
+		t.Errorf("rule without principal should apply")
+	}
+	if FindSubsidiary(nil, p) != nil {
+		t.Errorf("no rules should not apply")
+	}
+}
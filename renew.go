@@ -0,0 +1,261 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/jlmucb/cloudproxy/go/util/verbose"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// Renew obtains a fresh certificate from the default certificate authority
+// server for the existing key and subject name in keys, then installs the new
+// certificate chain in keys, saving it to disk if the keys are stored on disk.
+func Renew(keys *tao.Keys) ([]*x509.Certificate, error) {
+	return defaultClient().Renew(keys)
+}
+
+// Renew obtains a fresh certificate from a certificate authority server for the
+// existing key and subject name in keys, then installs the new certificate
+// chain in keys, saving it to disk if the keys are stored on disk. The profile
+// of the existing certificate is requested again, if it matches one of the
+// default profiles. See renewalProfile.
+func (server *Server) Renew(keys *tao.Keys) ([]*x509.Certificate, error) {
+	old := keys.Cert["default"]
+	if old == nil {
+		return nil, fmt.Errorf("no existing certificate to renew")
+	}
+	certs, err := server.Submit(keys, renewalRequest(keys, old, ""))
+	if err != nil {
+		return nil, err
+	}
+	if err := installCerts(keys, certs); err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// renewalRequest makes a CSR for the same key, names, and lifetime as an
+// existing certificate, using the given profile, or if that is empty, the
+// profile of the existing certificate.
+func renewalRequest(keys *tao.Keys, old *x509.Certificate, profile string) *CSR {
+	keydata, _ := proto.Marshal(tao.MarshalVerifierProto(keys.VerifyingKey))
+	n := old.Subject
+	var ips, uris []string
+	for _, ip := range old.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	for _, u := range old.URIs {
+		uris = append(uris, u.String())
+	}
+	csr := &CSR{
+		PublicKey: keydata,
+		Name: &X509Details{
+			CommonName:         proto.String(n.CommonName),
+			Country:            proto.String(firstOf(n.Country)),
+			State:              proto.String(firstOf(n.Province)),
+			City:               proto.String(firstOf(n.Locality)),
+			Organization:       proto.String(firstOf(n.Organization)),
+			OrganizationalUnit: proto.String(firstOf(n.OrganizationalUnit)),
+			DnsName:            old.DNSNames,
+			IpAddress:          ips,
+			Uri:                uris,
+		},
+		IsCa: proto.Bool(old.IsCA),
+	}
+	csr.SetLifetime(old.NotAfter.Sub(old.NotBefore))
+	if profile == "" {
+		profile = renewalProfile(old)
+	}
+	if profile != "" {
+		csr.Profile = proto.String(profile)
+	}
+	return csr
+}
+
+// renewalProfile returns the name of the default profile that an existing
+// certificate was issued under, judging by its key usages, or "" if there is
+// none, e.g. because it was issued without a profile. Certificates issued under
+// profiles the CA configuration defines or overrides aren't recognized.
+func renewalProfile(old *x509.Certificate) string {
+	for _, line := range policy.DefaultProfiles {
+		p, err := policy.ParseProfile(line)
+		if err != nil || p.IsCA != old.IsCA || p.KeyUsage != old.KeyUsage {
+			continue
+		}
+		if len(p.ExtKeyUsage) != len(old.ExtKeyUsage) {
+			continue
+		}
+		same := true
+		for i, u := range p.ExtKeyUsage {
+			same = same && old.ExtKeyUsage[i] == u
+		}
+		if same {
+			return p.Name
+		}
+	}
+	return ""
+}
+
+func firstOf(s []string) string {
+	if len(s) == 0 {
+		return ""
+	}
+	return s[0]
+}
+
+// Renewer periodically renews the default certificate in a set of keys, and
+// keeps a TLS certificate up to date for use by a running TLS listener.
+type Renewer struct {
+	// Keys holds the key and certificates to be renewed.
+	Keys *tao.Keys
+
+	// Server is the certificate authority server to use. If nil, the default
+	// server is used, failing over between replicas. See Client.
+	Server *Server
+
+	// Profile is the certificate profile to request. If empty, the profile of
+	// the existing certificate is requested again, if it matches one of the
+	// default profiles.
+	Profile string
+
+	// Fraction is the portion of the certificate lifetime that should elapse
+	// before renewal is attempted. If zero, 2/3 is used.
+	Fraction float64
+
+	// Retry is the delay between failed renewal attempts. If zero, one hour is
+	// used. Retries are made more often as expiration nears.
+	Retry time.Duration
+
+	// renewLock serializes renewals, and protects the certificates in Keys.
+	// It is held while contacting the certificate authority server.
+	renewLock sync.Mutex
+
+	// lock protects tlsCert. It is held only to swap in a renewed certificate,
+	// so TLS handshakes are not held up by a slow renewal.
+	lock    sync.RWMutex
+	tlsCert *tls.Certificate
+}
+
+// NewRenewer creates a renewer for the given keys, using the default
+// certificate authority server.
+func NewRenewer(keys *tao.Keys) (*Renewer, error) {
+	tlsCert, err := tao.EncodeTLSCert(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &Renewer{Keys: keys, tlsCert: tlsCert}, nil
+}
+
+// GetCertificate returns the current TLS certificate. It is meant for use as
+// tls.Config.GetCertificate, so that renewed certificates take effect in a
+// running TLS listener.
+func (r *Renewer) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.tlsCert, nil
+}
+
+// RenewNow obtains and installs a fresh certificate. The new certificate chain
+// is obtained and saved before the TLS certificate is swapped, so handshakes
+// continue to use the old certificate in the meantime.
+func (r *Renewer) RenewNow() error {
+	client := defaultClient()
+	if r.Server != nil {
+		client = NewClient(r.Server)
+	}
+	r.renewLock.Lock()
+	defer r.renewLock.Unlock()
+	certs, err := client.renew(r.Keys, r.Profile)
+	if err != nil {
+		return err
+	}
+	tlsCert, err := tao.EncodeTLSCert(r.Keys)
+	if err != nil {
+		return err
+	}
+	r.lock.Lock()
+	r.tlsCert = tlsCert
+	r.lock.Unlock()
+	verbose.Printf("Renewed certificate, serial %v, expires %v\n", certs[0].SerialNumber, certs[0].NotAfter)
+	return nil
+}
+
+// current returns the certificate being renewed.
+func (r *Renewer) current() *x509.Certificate {
+	r.renewLock.Lock()
+	defer r.renewLock.Unlock()
+	return r.Keys.Cert["default"]
+}
+
+// next returns the time at which renewal should be attempted.
+func (r *Renewer) next() time.Time {
+	cert := r.current()
+	f := r.Fraction
+	if f <= 0 || f >= 1 {
+		f = 2.0 / 3.0
+	}
+	life := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(time.Duration(float64(life) * f))
+}
+
+// Run renews the certificate whenever it nears expiration. It does not return.
+func (r *Renewer) Run() {
+	for {
+		if d := r.next().Sub(time.Now()); d > 0 {
+			time.Sleep(d)
+		}
+		err := r.RenewNow()
+		if err == nil {
+			continue
+		}
+		cert := r.current()
+		fmt.Printf("Certificate renewal failed (expires %v): %s\n", cert.NotAfter, err)
+		retry := r.Retry
+		if retry <= 0 {
+			retry = time.Hour
+		}
+		if left := cert.NotAfter.Sub(time.Now()) / 10; left < retry {
+			retry = left
+		}
+		if retry < time.Minute {
+			retry = time.Minute
+		}
+		time.Sleep(retry)
+	}
+}
+
+// ListenAndServeTLS is like tao.ListenAndServeTLS, but it also renews the
+// default certificate in keys as needed, without restarting the listener.
+func ListenAndServeTLS(addr string, keys *tao.Keys) error {
+	r, err := NewRenewer(keys)
+	if err != nil {
+		return err
+	}
+	go r.Run()
+	srv := &http.Server{
+		Addr:      addr,
+		TLSConfig: &tls.Config{GetCertificate: r.GetCertificate},
+	}
+	return srv.ListenAndServeTLS("", "")
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/kevinawalsh/taoca/policy"
+)
+
+// testCert makes a self-signed certificate with the given key usages, and keys
+// holding its key.
+func testCert(t *testing.T, isCA bool, ku x509.KeyUsage, eku []x509.ExtKeyUsage) (*tao.Keys, *x509.Certificate) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "www.example.com"},
+		NotBefore:             now,
+		NotAfter:              now.Add(90 * 24 * time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              ku,
+		ExtKeyUsage:           eku,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := tao.FromX509(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tao.Keys{VerifyingKey: v}, cert
+}
+
+func TestRenewalKeepsProfile(t *testing.T) {
+	profiles, err := policy.LoadProfiles("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, p := range profiles {
+		keys, old := testCert(t, p.IsCA, p.KeyUsage, p.ExtKeyUsage)
+		csr := renewalRequest(keys, old, "")
+		if csr.GetProfile() != name {
+			t.Errorf("%s: renewal requested profile %q", name, csr.GetProfile())
+			continue
+		}
+		// The CA applies the profile's key usages to the renewed certificate.
+		renewed := profiles[csr.GetProfile()]
+		if renewed.KeyUsage != old.KeyUsage || !reflect.DeepEqual(renewed.ExtKeyUsage, old.ExtKeyUsage) {
+			t.Errorf("%s: renewal changes key usages from %v %v to %v %v", name,
+				old.KeyUsage, old.ExtKeyUsage, renewed.KeyUsage, renewed.ExtKeyUsage)
+		}
+	}
+
+	// A certificate issued without a profile is renewed without one.
+	keys, old := testCert(t, false, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment,
+		[]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth})
+	if csr := renewalRequest(keys, old, ""); csr.Profile != nil {
+		t.Errorf("default certificate: renewal requested profile %q", csr.GetProfile())
+	}
+
+	// An explicit profile takes precedence.
+	if csr := renewalRequest(keys, old, "client"); csr.GetProfile() != "client" {
+		t.Errorf("explicit profile: renewal requested profile %q", csr.GetProfile())
+	}
+}
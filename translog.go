@@ -0,0 +1,92 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package taoca
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/jlmucb/cloudproxy/go/tao"
+	"github.com/kevinawalsh/taoca/translog"
+)
+
+// TreeHeadSigningContext is the context used for CA signatures over tree heads.
+const TreeHeadSigningContext = "TaoCA Tree Head Signature"
+
+func treeHeadData(th *TreeHead) ([]byte, error) {
+	unsigned := *th
+	unsigned.Signature = nil
+	return proto.Marshal(&unsigned)
+}
+
+// SignTreeHead fills in the signature for a tree head.
+func SignTreeHead(key *tao.Signer, th *TreeHead) error {
+	data, err := treeHeadData(th)
+	if err != nil {
+		return err
+	}
+	th.Signature, err = key.Sign(data, TreeHeadSigningContext)
+	return err
+}
+
+// VerifyTreeHead checks the signature on a tree head.
+func VerifyTreeHead(key *tao.Verifier, th *TreeHead) (bool, error) {
+	data, err := treeHeadData(th)
+	if err != nil {
+		return false, err
+	}
+	return key.Verify(data, TreeHeadSigningContext, th.Signature)
+}
+
+// VerifyInclusion checks that an inclusion proof shows cert to be in the log
+// at the proof's tree head. It does not check the tree head signature.
+func VerifyInclusion(cert *x509.Certificate, p *InclusionProof) bool {
+	th := p.GetTreeHead()
+	return translog.VerifyInclusion(translog.LeafHash(cert.Raw), p.GetLeafIndex(),
+		th.GetTreeSize(), p.GetAuditPath(), th.GetRootHash())
+}
+
+// Consistency asks the default certificate authority server for a proof that
+// its log of issued certificates at size first is a prefix of the log at size
+// second. The keys are used to authenticate to the server.
+func Consistency(keys *tao.Keys, first, second int64) (*TreeHead, [][]byte, error) {
+	return defaultClient().Consistency(keys, first, second)
+}
+
+// Consistency asks a certificate authority server for a proof that its log of
+// issued certificates at size first is a prefix of the log at size second. If
+// second is zero, the latest signed tree head is used. Otherwise, the server
+// must already have signed a tree head of size second, e.g. when issuing a
+// certificate. The signed tree head for the later log is returned along with
+// the proof.
+func (server *Server) Consistency(keys *tao.Keys, first, second int64) (*TreeHead, [][]byte, error) {
+	t := RequestType_TAOCA_LOG_CONSISTENCY
+	req := &Request{
+		Type: &t,
+		Consistency: &ConsistencyQuery{
+			First:  proto.Int64(first),
+			Second: proto.Int64(second),
+		},
+	}
+	resp, err := server.request(keys, req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resp.TreeHead == nil {
+		return nil, nil, fmt.Errorf("no tree head in CA response")
+	}
+	return resp.TreeHead, resp.ConsistencyProof, nil
+}
@@ -0,0 +1,288 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package translog provides an append-only Merkle tree log of issued
+// certificates, in the style of Certificate Transparency (RFC 6962), along with
+// inclusion and consistency proofs that auditors can use to check that the log
+// is never rewritten.
+package translog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Note: Only the leaf hashes are stored, 32 bytes apiece, and the file is only
+// ever appended to. A partially written final hash, from a crash, is dropped
+// when the log is next opened.
+
+// Log is an append-only Merkle tree log, stored in a file.
+type Log struct {
+	// Path is the location of the log file.
+	Path string
+
+	lock   sync.Mutex
+	leaves [][]byte
+	index  map[string]int64
+}
+
+// LeafHash computes the Merkle tree hash of a log entry.
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// Open loads a log, creating an empty one if path does not exist.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, f); err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	if extra := len(b) % sha256.Size; extra != 0 {
+		b = b[:len(b)-extra]
+		if err := f.Truncate(int64(len(b))); err != nil {
+			return nil, err
+		}
+	}
+	l := &Log{Path: path, index: make(map[string]int64)}
+	for i := 0; i < len(b); i += sha256.Size {
+		leaf := b[i : i+sha256.Size]
+		l.index[string(leaf)] = int64(len(l.leaves))
+		l.leaves = append(l.leaves, leaf)
+	}
+	return l, nil
+}
+
+// Append durably adds an entry to the log and returns its index.
+func (l *Log) Append(data []byte) (int64, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	leaf := LeafHash(data)
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Write(leaf); err != nil {
+		return 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return 0, err
+	}
+	l.index[string(leaf)] = int64(len(l.leaves))
+	l.leaves = append(l.leaves, leaf)
+	return int64(len(l.leaves) - 1), nil
+}
+
+// Find returns the index of an entry in the log, if it is present.
+func (l *Log) Find(data []byte) (int64, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	i, ok := l.index[string(LeafHash(data))]
+	return i, ok
+}
+
+// Size returns the number of entries in the log.
+func (l *Log) Size() int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return int64(len(l.leaves))
+}
+
+// snapshot returns the first size leaf hashes.
+func (l *Log) snapshot(size int64) ([][]byte, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if size < 0 || size > int64(len(l.leaves)) {
+		return nil, fmt.Errorf("log has %d entries, not %d", len(l.leaves), size)
+	}
+	return l.leaves[:size], nil
+}
+
+// Root returns the Merkle tree hash of the first size entries in the log.
+func (l *Log) Root(size int64) ([]byte, error) {
+	leaves, err := l.snapshot(size)
+	if err != nil {
+		return nil, err
+	}
+	return root(leaves), nil
+}
+
+// InclusionProof returns the audit path for an entry in the tree formed by the
+// first size entries in the log.
+func (l *Log) InclusionProof(index, size int64) ([][]byte, error) {
+	leaves, err := l.snapshot(size)
+	if err != nil {
+		return nil, err
+	}
+	if index < 0 || index >= size {
+		return nil, fmt.Errorf("entry %d is not in tree of size %d", index, size)
+	}
+	return auditPath(index, leaves), nil
+}
+
+// ConsistencyProof returns a proof that the tree formed by the first entries
+// in the log is a prefix of the tree formed by the first second entries.
+func (l *Log) ConsistencyProof(first, second int64) ([][]byte, error) {
+	leaves, err := l.snapshot(second)
+	if err != nil {
+		return nil, err
+	}
+	if first < 0 || first > second {
+		return nil, fmt.Errorf("bad tree sizes %d and %d", first, second)
+	}
+	if first == 0 || first == second {
+		return nil, nil
+	}
+	return subproof(first, leaves, true), nil
+}
+
+// split returns the largest power of two less than n, for n > 1.
+func split(n int64) int64 {
+	k := int64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func root(leaves [][]byte) []byte {
+	n := int64(len(leaves))
+	switch n {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := split(n)
+	return nodeHash(root(leaves[:k]), root(leaves[k:]))
+}
+
+func auditPath(m int64, leaves [][]byte) [][]byte {
+	n := int64(len(leaves))
+	if n <= 1 {
+		return nil
+	}
+	k := split(n)
+	if m < k {
+		return append(auditPath(m, leaves[:k]), root(leaves[k:]))
+	}
+	return append(auditPath(m-k, leaves[k:]), root(leaves[:k]))
+}
+
+func subproof(m int64, leaves [][]byte, complete bool) [][]byte {
+	n := int64(len(leaves))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{root(leaves)}
+	}
+	k := split(n)
+	if m <= k {
+		return append(subproof(m, leaves[:k], complete), root(leaves[k:]))
+	}
+	return append(subproof(m-k, leaves[k:], false), root(leaves[:k]))
+}
+
+// VerifyInclusion checks an audit path showing that the entry with the given
+// leaf hash is at index in the tree of the given size and root hash.
+func VerifyInclusion(leaf []byte, index, size int64, proof [][]byte, rootHash []byte) bool {
+	if index < 0 || index >= size {
+		return false
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(r, rootHash)
+}
+
+// VerifyConsistency checks a proof that the tree of size first and root hash
+// firstRoot is a prefix of the tree of size second and root hash secondRoot.
+func VerifyConsistency(first, second int64, firstRoot, secondRoot []byte, proof [][]byte) bool {
+	switch {
+	case first < 0 || first > second:
+		return false
+	case first == 0:
+		return len(proof) == 0
+	case first == second:
+		return len(proof) == 0 && bytes.Equal(firstRoot, secondRoot)
+	}
+	if first&(first-1) == 0 {
+		proof = append([][]byte{firstRoot}, proof...)
+	}
+	if len(proof) == 0 {
+		return false
+	}
+	fn, sn := first-1, second-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, firstRoot) && bytes.Equal(sr, secondRoot)
+}
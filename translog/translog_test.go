@@ -0,0 +1,100 @@
+// Copyright (c) 2015, Kevin Walsh.  All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package translog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestProofs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "translog_test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p := path.Join(dir, "translog")
+
+	l, err := Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	for i := 0; i < n; i++ {
+		if idx, err := l.Append([]byte(fmt.Sprintf("entry %d", i))); err != nil || idx != int64(i) {
+			t.Fatalf("append %d: index %d, %v", i, idx, err)
+		}
+	}
+
+	// Reopen, after simulating a crash in the middle of an append.
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("partial"))
+	f.Close()
+	l, err = Open(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Size() != n {
+		t.Fatalf("expected %d entries, found %d", n, l.Size())
+	}
+	if i, ok := l.Find([]byte("entry 7")); !ok || i != 7 {
+		t.Fatalf("expected to find entry 7, found %d", i)
+	}
+	if _, ok := l.Find([]byte("entry 99")); ok {
+		t.Fatal("found entry that is not in the log")
+	}
+
+	roots := make([][]byte, n+1)
+	for size := int64(0); size <= n; size++ {
+		if roots[size], err = l.Root(size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for size := int64(1); size <= n; size++ {
+		for i := int64(0); i < size; i++ {
+			proof, err := l.InclusionProof(i, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			leaf := LeafHash([]byte(fmt.Sprintf("entry %d", i)))
+			if !VerifyInclusion(leaf, i, size, proof, roots[size]) {
+				t.Fatalf("inclusion proof for %d in %d failed", i, size)
+			}
+			if VerifyInclusion(leaf, i, size, proof, roots[size-1]) {
+				t.Fatalf("inclusion proof for %d in %d verified against wrong root", i, size)
+			}
+		}
+	}
+	for second := int64(0); second <= n; second++ {
+		for first := int64(0); first <= second; first++ {
+			proof, err := l.ConsistencyProof(first, second)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !VerifyConsistency(first, second, roots[first], roots[second], proof) {
+				t.Fatalf("consistency proof for %d, %d failed", first, second)
+			}
+			if first > 0 && first < second && VerifyConsistency(first, second, roots[first-1], roots[second], proof) {
+				t.Fatalf("consistency proof for %d, %d verified against wrong root", first, second)
+			}
+		}
+	}
+}
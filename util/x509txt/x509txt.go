@@ -77,6 +77,11 @@ func Dump(w indent.Writer, cert *x509.Certificate) {
 		w.Println(w.Bold("%s", strings.Join(s, ", ")))
 		w.Dedent()
 	}
+	if names := AltNames(cert); len(names) > 0 {
+		w.Headerf("X509v3 Subject Alternative Name:\n")
+		w.Println(w.Bold("%s", strings.Join(names, ", ")))
+		w.Dedent()
+	}
 	if cert.BasicConstraintsValid {
 		w.Headerf("X509v3 Basic Constraints:\n")
 		w.Printf("CA: %s\n", w.Bold("%v", cert.IsCA))
@@ -85,11 +90,24 @@ func Dump(w indent.Writer, cert *x509.Certificate) {
 		}
 		w.Dedent()
 	}
+	if len(cert.PermittedDNSDomains) > 0 || len(cert.PermittedIPRanges) > 0 {
+		w.Headerf("X509v3 Name Constraints:\n")
+		for _, d := range cert.PermittedDNSDomains {
+			w.Printf("Permitted: %s\n", w.Bold("DNS:%s", d))
+		}
+		for _, r := range cert.PermittedIPRanges {
+			w.Printf("Permitted: %s\n", w.Bold("IP:%s", r))
+		}
+		w.Dedent()
+	}
 	for _, e := range cert.Extensions {
 		if cps, unotice, err := ExtractCertificationPolicy(e); err == nil {
 			w.Headerf("Policy:\n")
 			w.Printf("CPS: %s\n", w.Link(cps, w.Bold(cps)))
 			w.Printf("User Notice: %s\n", w.Link(unotice, w.Bold(unotice)))
+			if notice, err := ExtractNotice(e); err == nil {
+				w.Printf("Machine-readable Notice: %s\n", w.Link(notice, w.Bold(notice)))
+			}
 			w.Dedent()
 		}
 	}
@@ -233,6 +251,22 @@ func pubkeyDump(w indent.Writer, cert *x509.Certificate) {
 // OU=Cloudproxy", more or less according to rfc 4514. Note that pkix.Name does
 // not appear to preserve the structure of multi-valued RDNs, so '+' is not used
 // the encoding. Non-standard RDN types are ignored.
+// AltNames returns the subject alternative names of a certificate, each
+// prefixed by its type, e.g. "DNS:example.com", "IP:192.168.1.3".
+func AltNames(cert *x509.Certificate) []string {
+	var s []string
+	for _, n := range cert.DNSNames {
+		s = append(s, "DNS:"+n)
+	}
+	for _, ip := range cert.IPAddresses {
+		s = append(s, "IP:"+ip.String())
+	}
+	for _, u := range cert.URIs {
+		s = append(s, "URI:"+u.String())
+	}
+	return s
+}
+
 func RDNString(n pkix.Name) string {
 	// Shorthand for PostalCode and SerialNumber does not seem to be defined,
 	// so we use ZIP and SERIAL.
@@ -333,9 +367,29 @@ var (
 	asn1VisibleStringTag   byte = 26
 )
 
+// ExtractCertificationPolicy returns the urls of the certification practices
+// statement and user notice from a TaoCA certificate policies extension.
 func ExtractCertificationPolicy(e pkix.Extension) (cps, unotice string, err error) {
+	cps, _, unotice, err = extractPolicyQualifiers(e)
+	return
+}
+
+// ExtractNotice returns the url of the machine-readable user notice linked from
+// a TaoCA certificate policies extension, i.e. the second CPS qualifier.
+func ExtractNotice(e pkix.Extension) (notice string, err error) {
+	_, notice, _, err = extractPolicyQualifiers(e)
+	if err == nil && notice == "" {
+		err = fmt.Errorf("No machine-readable user notice in x509 Policy extension")
+	}
+	return
+}
+
+// extractPolicyQualifiers parses a TaoCA certificate policies extension, which
+// holds a CPS qualifier, optionally a second CPS qualifier for the
+// machine-readable user notice, then a user notice qualifier.
+func extractPolicyQualifiers(e pkix.Extension) (cps, notice, unotice string, err error) {
 	if !e.Id.Equal(idCertificatePolicies) {
-		return "", "", fmt.Errorf("ASN OID mismatch")
+		return "", "", "", fmt.Errorf("ASN OID mismatch")
 	}
 	var pi []struct {
 		Id               asn1.ObjectIdentifier
@@ -346,43 +400,46 @@ func ExtractCertificationPolicy(e pkix.Extension) (cps, unotice string, err erro
 	}
 	rest, err := asn1.Unmarshal(e.Value, &pi)
 	if err != nil {
-		return "", "", err
+		return "", "", "", err
 	}
 	if len(rest) > 0 {
-		return "", "", fmt.Errorf("Trailing data after x509 Policy extension: % 02x", rest)
+		return "", "", "", fmt.Errorf("Trailing data after x509 Policy extension: % 02x", rest)
 	}
 	if len(pi) != 1 {
-		return "", "", fmt.Errorf("Unexpected count for x509 Policy extension: %d", len(pi))
+		return "", "", "", fmt.Errorf("Unexpected count for x509 Policy extension: %d", len(pi))
 	}
 	if !pi[0].Id.Equal(idSubjectIdentityValidated) {
-		return "", "", fmt.Errorf("Unrecognized OID for x509 Policy extension: %v", pi[0].Id)
+		return "", "", "", fmt.Errorf("Unrecognized OID for x509 Policy extension: %v", pi[0].Id)
 	}
 	q := pi[0].PolicyQualifiers
-	if len(q) != 2 {
-		return "", "", fmt.Errorf("Unexpected count for x509 Policy extension qualifiers: %d", len(pi[0].PolicyQualifiers))
-	}
-	if !q[0].Id.Equal(idQtCertificationPracticeStatement) {
-		return "", "", fmt.Errorf("Unrecognized OID for x509 Policy extension CPS: %v", q[0].Id)
+	if len(q) != 2 && len(q) != 3 {
+		return "", "", "", fmt.Errorf("Unexpected count for x509 Policy extension qualifiers: %d", len(pi[0].PolicyQualifiers))
 	}
-	rest, err = asn1.Unmarshal(q[0].Value.FullBytes, &cps)
-	if err != nil {
-		return "", "", fmt.Errorf("Error extracting CPS: %v", err)
-	}
-	if len(rest) > 0 {
-		return "", "", fmt.Errorf("Trailing data after x509 Policy extension CPS: % 02x", rest)
+	for i, s := range []*string{&cps, &notice}[:len(q)-1] {
+		if !q[i].Id.Equal(idQtCertificationPracticeStatement) {
+			return "", "", "", fmt.Errorf("Unrecognized OID for x509 Policy extension CPS: %v", q[i].Id)
+		}
+		rest, err = asn1.Unmarshal(q[i].Value.FullBytes, s)
+		if err != nil {
+			return "", "", "", fmt.Errorf("Error extracting CPS: %v", err)
+		}
+		if len(rest) > 0 {
+			return "", "", "", fmt.Errorf("Trailing data after x509 Policy extension CPS: % 02x", rest)
+		}
 	}
-	if !q[1].Id.Equal(idQtUnotice) {
-		return "", "", fmt.Errorf("Unrecognized OID for x509 Policy extension User Notice: %v", q[1].Id)
+	u := q[len(q)-1]
+	if !u.Id.Equal(idQtUnotice) {
+		return "", "", "", fmt.Errorf("Unrecognized OID for x509 Policy extension User Notice: %v", u.Id)
 	}
-	if len(q[1].Value.Bytes) > 0 && q[1].Value.Bytes[0] == asn1VisibleStringTag {
-		q[1].Value.Bytes[0] = asn1PrintableStringTag
+	if len(u.Value.Bytes) > 0 && u.Value.Bytes[0] == asn1VisibleStringTag {
+		u.Value.Bytes[0] = asn1PrintableStringTag
 	}
-	rest, err = asn1.Unmarshal(q[1].Value.Bytes, &unotice)
+	rest, err = asn1.Unmarshal(u.Value.Bytes, &unotice)
 	if err != nil {
-		return "", "", fmt.Errorf("Error extracting user notice: %v", err)
+		return "", "", "", fmt.Errorf("Error extracting user notice: %v", err)
 	}
 	if len(rest) > 0 {
-		return "", "", fmt.Errorf("Trailing data after x509 Policy extension User Notice: % 02x", rest)
+		return "", "", "", fmt.Errorf("Trailing data after x509 Policy extension User Notice: % 02x", rest)
 	}
-	return cps, unotice, nil
+	return cps, notice, unotice, nil
 }
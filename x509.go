@@ -95,19 +95,34 @@ var (
 // certification policy, including a statement and a user notice. The resulting
 // extension can be added to x509.Certficate.ExtraExtensions.
 func NewCertficationPolicy(cps, unotice string) (pkix.Extension, error) {
+	return NewCertficationPolicyWithNotice(cps, unotice, "")
+}
+
+// NewCertficationPolicyWithNotice is like NewCertficationPolicy, but the
+// extension also links to a machine-readable user notice, if notice is not
+// empty. The link is carried as a second CPS qualifier, following the one for
+// the statement.
+func NewCertficationPolicyWithNotice(cps, unotice, notice string) (pkix.Extension, error) {
+	qualifiers := []interface{}{
+		policyQualifierInfo{
+			PolicyQualifierId: idQtCertificationPracticeStatement,
+			Qualifier:         cps,
+		},
+	}
+	if notice != "" {
+		qualifiers = append(qualifiers, policyQualifierInfo{
+			PolicyQualifierId: idQtCertificationPracticeStatement,
+			Qualifier:         notice,
+		})
+	}
+	qualifiers = append(qualifiers, policyQualifierInfoSequence{
+		PolicyQualifierId: idQtUnotice,
+		Qualifier:         []string{unotice},
+	})
 	pi := []policyInformation{
 		policyInformation{
 			PolicyIdentifier: idSubjectIdentityValidated,
-			PolicyQualifiers: []interface{}{
-				policyQualifierInfo{
-					PolicyQualifierId: idQtCertificationPracticeStatement,
-					Qualifier:         cps,
-				},
-				policyQualifierInfoSequence{
-					PolicyQualifierId: idQtUnotice,
-					Qualifier:         []string{unotice},
-				},
-			},
+			PolicyQualifiers: qualifiers,
 		},
 	}
 	asn1Bytes, err := asn1.Marshal(pi)
@@ -94,7 +94,7 @@ func main() {
 	http.Handle("/index.html", http.RedirectHandler("/", 301))
 	http.HandleFunc("/", pwgen)
 	fmt.Printf("Listening at %s using HTTPS\n", addr)
-	err := tao.ListenAndServeTLS(addr, keys)
+	err := taoca.ListenAndServeTLS(addr, keys)
 	options.FailIf(err, "can't listen and serve")
 
 	fmt.Println("Server Done")